@@ -1,7 +1,7 @@
 package main
 
 import (
-	"database/sql"
+	"errors"
 	"fmt"
 	"github.com/loudbund/go-pgsql/pgsql_v1"
 	"log"
@@ -16,54 +16,40 @@ func main() {
 }
 
 func runTransaction() {
-	Db := pgsql_v1.Handle().GetDb()
-	var KeyTx *sql.Tx
-	if tx, err := Db.Begin(); err != nil {
-		log.Panic(err)
-	} else {
-		KeyTx = tx
-	}
-
-	if true {
-		Sql, Vals := pgsql_v1.Handle().UtilInsert("demo", map[string]interface{}{
+	err := pgsql_v1.Handle().Transaction(func(tx *pgsql_v1.Tx) error {
+		if _, err := tx.Insert("demo", map[string]interface{}{
 			"status":  1,
 			"debug":   "test Insert11",
 			"creator": "123",
-		})
-		if _, err := KeyTx.Exec(pgsql_v1.UtilFormatExec(Sql), Vals...); err != nil {
-			fmt.Println(err)
-			_ = KeyTx.Rollback()
-			return
+		}); err != nil {
+			return err
 		}
-	}
-	if true {
-		Sql, vals := pgsql_v1.Handle().UtilUpdate("demo", map[string]interface{}{
-			"id":      3,
+
+		if err := tx.Update("demo", map[string]interface{}{
 			"status":  1,
 			"debug":   "test Insert update",
 			"creator": "123",
 		}, map[string]interface{}{
 			"id": 3,
-		})
-		if _, err := KeyTx.Exec(pgsql_v1.UtilFormatExec(Sql), vals...); err != nil {
-			fmt.Println(err)
-			_ = KeyTx.Rollback()
-			return
+		}); err != nil {
+			return err
 		}
-	}
 
-	if true {
-		Sql, vals := pgsql_v1.Handle().UtilDelete("demo", map[string]interface{}{
-			"id": 5,
-		})
-		if _, err := KeyTx.Exec(pgsql_v1.UtilFormatExec(Sql), vals...); err != nil {
+		// 嵌套事务：出错只回滚到保存点，外层事务继续
+		if err := tx.Transaction(func(tx *pgsql_v1.Tx) error {
+			if err := tx.Delete("demo", map[string]interface{}{
+				"id": 5,
+			}); err != nil {
+				return err
+			}
+			return errors.New("回滚删除操作")
+		}); err != nil {
 			fmt.Println(err)
-			_ = KeyTx.Rollback()
-			return
 		}
-	}
 
-	if err := KeyTx.Commit(); err != nil {
-		_ = KeyTx.Rollback()
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
 	}
 }
@@ -1,12 +1,17 @@
 package pgsql_v1
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"github.com/larspensjo/config"
 	_ "github.com/lib/pq"
 	log "github.com/sirupsen/logrus"
+	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -15,50 +20,259 @@ var (
 	handles    = make(map[string]*ormPgsql) // 实例句柄
 	handleLock = sync.RWMutex{}             // 实例句柄操作锁
 	pathConfig = ""                         // 配置文件地址
-	dbSections = map[string]string{}        // 名称=> 数据库
+	dbSections = map[string]Config{}        // 名称=> 数据库配置
+	fileSect   = map[string]bool{}          // 从配置文件读取的配置名称，重新加载时只处理这些配置
+
+	replicaRetry = 30 * time.Second // 只读库连接失败后暂停使用的时间，之后重新尝试
 )
 
-// @Title 初始化配置文件路径
+// 连接池，句柄复制时共享；重新加载配置时替换其中的数据库句柄，已获取的句柄也使用新的连接
+type dbPool struct {
+	dbs  atomic.Value // *poolDbs
+	next uint64       // 轮询只读库的计数
+}
+
+// 连接池中的数据库句柄
+type poolDbs struct {
+	primary   *sql.DB   // 主库
+	replicas  []*sql.DB // 只读库
+	balance   string    // 只读库的负载均衡方式
+	downUntil []int64   // 各只读库连接失败后暂停使用的截止时间(UnixNano)，原子读写；调整连接池时共用
+}
+
+func newDbPool(dbs *poolDbs) *dbPool {
+	p := &dbPool{}
+	p.dbs.Store(dbs)
+	return p
+}
+
+// 当前的数据库句柄
+func (p *dbPool) load() *poolDbs {
+	if p == nil {
+		return nil
+	}
+	return p.dbs.Load().(*poolDbs)
+}
+
+// 主库句柄
+func (p *dbPool) get() *sql.DB {
+	if dbs := p.load(); dbs != nil {
+		return dbs.primary
+	}
+	return nil
+}
+
+// 按 balance 从可用的只读库中选择一个，返回序号；没有可用的只读库时返回-1
+func (p *dbPool) pick() int {
+	dbs := p.load()
+	if dbs == nil {
+		return -1
+	}
+	ups := make([]int, 0, len(dbs.replicas))
+	for i := range dbs.replicas {
+		if dbs.isUp(i) {
+			ups = append(ups, i)
+		}
+	}
+	if len(ups) == 0 {
+		return -1
+	}
+	if dbs.balance == BalanceLeastConn {
+		pick, inUse := ups[0], dbs.replicas[ups[0]].Stats().InUse
+		for _, i := range ups[1:] {
+			if n := dbs.replicas[i].Stats().InUse; n < inUse {
+				pick, inUse = i, n
+			}
+		}
+		return pick
+	}
+	i := atomic.AddUint64(&p.next, 1)
+	return ups[i%uint64(len(ups))]
+}
+
+// 序号对应的只读库句柄，重新加载配置后序号超出范围或只读库暂停使用时使用主库
+func (p *dbPool) replica(i int) *sql.DB {
+	dbs := p.load()
+	if dbs == nil || i < 0 || i >= len(dbs.replicas) || !dbs.isUp(i) {
+		return p.get()
+	}
+	return dbs.replicas[i]
+}
+
+// 只读库连接失败，暂停使用 replicaRetry 时间
+func (p *dbPool) markDown(i int) {
+	if dbs := p.load(); dbs != nil {
+		dbs.markDown(i)
+	}
+}
+
+// 替换数据库句柄，返回旧的句柄
+func (p *dbPool) swap(dbs *poolDbs) *poolDbs {
+	old := p.load()
+	p.dbs.Store(dbs)
+	return old
+}
+
+// 只读库是否可用：没有连接失败或已超过暂停时间
+func (d *poolDbs) isUp(i int) bool {
+	return i >= len(d.downUntil) || time.Now().UnixNano() >= atomic.LoadInt64(&d.downUntil[i])
+}
+
+// 只读库连接失败，暂停使用 replicaRetry 时间
+func (d *poolDbs) markDown(i int) {
+	if i >= 0 && i < len(d.downUntil) {
+		atomic.StoreInt64(&d.downUntil[i], time.Now().Add(replicaRetry).UnixNano())
+	}
+}
+
+// 全部句柄，主库在前
+func (d *poolDbs) all() []*sql.DB {
+	return append([]*sql.DB{d.primary}, d.replicas...)
+}
+
+// 关闭全部句柄
+func (d *poolDbs) close() {
+	for _, v := range d.all() {
+		_ = v.Close()
+	}
+}
+
+// @Title 初始化配置文件路径，读取配置文件或创建连接出错时panic
+// 说明：配置项可以用环境变量覆盖，变量名为 PG_配置名称_配置项(大写)，如 [pg_default] 的 host => PG_DEFAULT_HOST；
+// 只创建连接不检测，数据库连不上时第一次使用返回错误，需要初始化时检测连接请使用 InitE
 func Init(cfgPath string) {
+	if err := initFile(cfgPath, false); err != nil {
+		log.Panic(err)
+	}
+}
 
-	// 配置文件路径赋值
-	pathConfig = cfgPath
+// @Title 初始化配置文件路径，出错时返回error，不会panic
+// 说明：配置段出错或连接失败时返回 *UInitError，包含配置名称和原因；设置了 optional = true 的配置段连接失败时只记录警告，
+// 设置了 lazy = true 的配置段初始化时不连接，第一次 Handle 时才创建连接
+func InitE(cfgPath string) error {
+	return initFile(cfgPath, true)
+}
+
+// 读取配置文件，登记并初始化全部配置的连接，ping为true时检测连接
+func initFile(cfgPath string, ping bool) error {
 
 	// 读取配置文件
-	cfg, err := config.ReadDefault(pathConfig)
+	configs, err := readConfigFile(cfgPath)
+	if err != nil {
+		return err
+	}
+
+	// 配置文件路径赋值，和重新加载使用同一个锁
+	reloadLock.Lock()
+	pathConfig = cfgPath
+	reloadLock.Unlock()
+
+	// 登记全部配置，再初始化所有数据库配置项的连接
+	handleLock.Lock()
+	for name, c := range configs {
+		dbSections[name] = c
+		fileSect[name] = true
+	}
+	handleLock.Unlock()
+	for name, c := range configs {
+		if err := initConnect(name, c, ping); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 初始化一个配置的连接，ping为true时检测连接：lazy 时不连接；连接失败时删除句柄，optional 时只记录警告
+func initConnect(name string, c Config, ping bool) error {
+	if c.Lazy {
+		return nil
+	}
+	handle, err := getConnectedHandle(name)
+	if err == nil && ping {
+		if err = pingDb(handle.pool.load(), c); err != nil {
+			handleLock.Lock()
+			if handles[handle.dbInstance] == handle {
+				delete(handles, handle.dbInstance)
+			}
+			handleLock.Unlock()
+			handle.pool.load().close()
+		}
+	}
+	if err == nil {
+		return nil
+	}
+	err = &UInitError{Name: name, Err: err}
+	if c.Optional {
+		log.Warn(err)
+		return nil
+	}
+	return err
+}
+
+// 读取配置文件中全部 pg_ 开头的配置段，环境变量覆盖配置文件中的配置项
+func readConfigFile(path string) (map[string]Config, error) {
+	cfg, err := config.ReadDefault(path)
 	if err != nil {
-		log.Panic("读取配置文件出错" + err.Error())
-		return
+		return nil, fmt.Errorf("读取配置文件 %s 出错: %w", path, err)
 	}
 
-	// 初始化所有数据库配置项的连接
+	configs := map[string]Config{}
 	for _, v := range cfg.Sections() {
-		if v[:3] == "pg_" {
-			dbSections[v[3:]], _ = cfg.String(v, "pg")
-			_, err := getConnectedHandle(v[3:])
-			if err != nil {
-				log.Panic("数据库连接初始化失败", v)
+		if !strings.HasPrefix(v, "pg_") {
+			continue
+		}
+		section := v
+		c, err := utilConfigFromLookup(func(key string) (string, bool) {
+			if val, ok := os.LookupEnv(utilConfigEnvName(section[3:], key)); ok {
+				return val, true
+			}
+			if !cfg.HasOption(section, key) {
+				return "", false
+			}
+			val, err := cfg.String(section, key)
+			return val, err == nil
+		})
+		if err != nil {
+			return nil, &UInitError{Name: section[3:], Err: err}
+		}
+
+		// opt_ 开头的配置项作为其他连接参数
+		options, _ := cfg.SectionOptions(section)
+		for _, key := range options {
+			if !strings.HasPrefix(key, configOptionPrefix) {
+				continue
+			}
+			val, ok := os.LookupEnv(utilConfigEnvName(section[3:], key))
+			if !ok {
+				val, _ = cfg.String(section, key)
 			}
+			c.setOption(key[len(configOptionPrefix):], val)
 		}
+		if err := c.check(); err != nil {
+			return nil, &UInitError{Name: section[3:], Err: err}
+		}
+		c.setDefaults()
+		configs[section[3:]] = c
 	}
+	return configs, nil
 }
 
 // 初始化
 func getConnectedHandle(dbCfgName string, varDbName ...string) (*ormPgsql, error) {
-	// 判断配置文件是否已赋值
-	if pathConfig == "" {
-		log.Panic("请先初始化设置数据库配置文件")
-		return nil, errors.New("请先初始化设置数据库配置文件")
-	}
-
 	// 没有数据库配置项
-	if _, ok := dbSections[dbCfgName]; !ok {
-		log.Error(pathConfig + " 没有数据库 " + dbCfgName + " 这个配置项 ")
-		return nil, errors.New(pathConfig + " 没有数据库 " + dbCfgName + " 这个配置项 ")
+	handleLock.RLock()
+	c, ok := dbSections[dbCfgName]
+	empty := len(dbSections) == 0
+	handleLock.RUnlock()
+	if !ok {
+		if empty {
+			return nil, errors.New("请先初始化设置数据库配置")
+		}
+		return nil, errors.New("没有这个配置项")
 	}
 
 	// 数据库名称
-	dbName := dbSections[dbCfgName]
+	dbName := ""
 	if len(varDbName) > 0 {
 		dbName = varDbName[0]
 	}
@@ -67,117 +281,127 @@ func getConnectedHandle(dbCfgName string, varDbName ...string) (*ormPgsql, error
 	var dbInstance = "dbInstance|" + dbCfgName + "|" + dbName
 
 	// 句柄已存在，直接返回
-	if true {
-		handleLock.Lock()
-		_, ok := handles[dbInstance]
-		handleLock.Unlock()
-
-		if ok {
-			return handles[dbInstance], nil
-		}
-	}
-
-	// 读取配置文件
-	host, port, db, username, password, _, maxIdle, maxConn, interpolateParams, maxLifetime, err := getDbConfig("pg_" + dbCfgName)
-	if err != nil {
-		log.Error("读取配置文件出错:" + err.Error())
-		return nil, err
+	handleLock.RLock()
+	oneHandle, ok := handles[dbInstance]
+	handleLock.RUnlock()
+	if ok {
+		return oneHandle, nil
 	}
 
 	// 参数传了数据库名称，则使用传入的数据库名称
+	db := c.Db
 	if dbName != "" {
 		db = dbName
 	}
 
 	// 连接数据库
-	dataSourceName := "host=" + host + " port=" + port + " user=" + username + " password=" + password + " dbname=" + db + " sslmode=disable"
-	if interpolateParams {
-		dataSourceName += "&interpolateParams=true"
-	}
-	dbHandle, err := sql.Open("postgres", dataSourceName)
+	dbs, err := openDb(c, db)
 	if err != nil {
-		log.Error(err)
-		log.Error("数据库:"+dbCfgName+" . "+dbName, " 连接失败")
 		return nil, err
 	}
-	dbHandle.SetMaxOpenConns(maxConn)
-	dbHandle.SetMaxIdleConns(maxIdle)
-	dbHandle.SetConnMaxLifetime(time.Second * time.Duration(maxLifetime))
 
-	oneHandle := &ormPgsql{
-		o:          dbHandle,
+	oneHandle = &ormPgsql{
+		pool:       newDbPool(dbs),
 		dbInstance: dbInstance,
 		dbCfgName:  dbCfgName,
 		dbName:     dbName,
+		scanMode:   c.ScanMode,
 		initErr:    false,
 	}
 
 	handleLock.Lock()
+	if exist, ok := handles[dbInstance]; ok { // 并发创建时使用先创建的句柄
+		handleLock.Unlock()
+		dbs.close()
+		return exist, nil
+	}
+	if cur, ok := dbSections[dbCfgName]; !ok || len(utilConfigChanges(c, cur)) > 0 { // 创建过程中配置被重新加载，按新配置重新创建
+		handleLock.Unlock()
+		dbs.close()
+		return getConnectedHandle(dbCfgName, varDbName...)
+	}
 	handles[dbInstance] = oneHandle
 	handleLock.Unlock()
 
 	return oneHandle, nil
 }
 
-// @Title 获取数据库句柄，所有配置信息从配置文件读取
-func Handle(Name ...string) *ormPgsql {
-	// 有参数使用传入的参数，否则使用default
-	if len(Name) == 0 {
-		Name = append(Name, "default")
+// 按配置打开主库和只读库的句柄，db为数据库名
+func openDb(c Config, db string) (*poolDbs, error) {
+	dbs := &poolDbs{balance: c.Balance}
+	for i, cfg := range append([]Config{c}, c.replicaConfigs()...) {
+		dbHandle, err := sql.Open("postgres", cfg.dsn(db))
+		if err != nil {
+			for _, v := range append(dbs.replicas, dbs.primary) {
+				if v != nil {
+					_ = v.Close()
+				}
+			}
+			return nil, err
+		}
+		if i == 0 {
+			dbs.primary = dbHandle
+		} else {
+			dbs.replicas = append(dbs.replicas, dbHandle)
+		}
 	}
+	dbs.downUntil = make([]int64, len(dbs.replicas))
+	setPoolLimits(dbs, c)
+	return dbs, nil
+}
 
-	// 获取指定配置和库名的句柄
-	handle, err := getConnectedHandle(Name[0], Name[1:]...)
-	if err != nil {
-		return &ormPgsql{initErr: true}
+// 检测数据库连接，超时时间为 connect_timeout，未设置时为10秒；只读库连接失败只记录警告，并暂停使用 replicaRetry 时间
+func pingDb(dbs *poolDbs, c Config) error {
+	timeout := 10 * time.Second
+	if c.ConnectTimeout > 0 {
+		timeout = time.Duration(c.ConnectTimeout) * time.Second
 	}
-
-	// 返回连接句柄
-	return handle
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := dbs.primary.PingContext(ctx); err != nil {
+		return err
+	}
+	for i, v := range dbs.replicas {
+		if err := v.PingContext(ctx); err != nil {
+			dbs.markDown(i)
+			log.Warn("只读库 " + c.Replicas[i] + " 连接失败，暂停使用: " + err.Error())
+		}
+	}
+	return nil
 }
 
-// @Title 获取配置文件
-func getDbConfig(name string) (host string, port string, db string, username string, password string, charset string, maxIdle int, maxConn int, interpolateParams bool, maxLifetime int, err error) {
+// 设置连接池的连接数和过期时间，主库和每个只读库分别设置
+func setPoolLimits(dbs *poolDbs, c Config) {
+	for _, dbHandle := range dbs.all() {
+		dbHandle.SetMaxOpenConns(c.MaxConn)
+		dbHandle.SetMaxIdleConns(c.MaxIdle)
+		dbHandle.SetConnMaxLifetime(time.Second * time.Duration(c.MaxLifetime))
+	}
+}
 
-	// 读取配置文件
-	cfg, err := config.ReadDefault(pathConfig)
+// @Title 获取数据库句柄，所有配置信息从初始化的配置读取，出错时返回的句柄所有操作都返回"数据库未连接成功"
+func Handle(Name ...string) *ormPgsql {
+	handle, err := HandleE(Name...)
 	if err != nil {
-		log.Error("读取配置文件出错" + err.Error())
-		return "", "", "", "", "", "", 0, 0, false, 0, err
+		log.Error(err)
+		return &ormPgsql{initErr: true}
 	}
 
-	// 取出配置项
-	host, hostErr := cfg.String(name, "host")
-	port, _ = cfg.String(name, "port")
-	db, _ = cfg.String(name, "db")
-	username, usernameErr := cfg.String(name, "username")
-	password, passwordErr := cfg.String(name, "password")
-	charset, charsetErr := cfg.String(name, "charset")
-	maxIdle, maxIdleErr := cfg.Int(name, "maxIdle")
-	maxConn, maxConnErr := cfg.Int(name, "maxConn")
-	interpolateParams, _ = cfg.Bool(name, "interpolateParams")
-	maxLifetime, _ = cfg.Int(name, "maxLifetime")
+	// 返回连接句柄
+	return handle
+}
 
-	// 主配置项出错
-	if hostErr != nil || usernameErr != nil || passwordErr != nil {
-		log.Error("出错")
-		return "", "", "", "", "", "", 0, 0, false, 0, errors.New(name + "数据库主配置项为空")
+// @Title 获取数据库句柄，参数同Handle，出错时返回 *UInitError，包含配置名称和原因
+func HandleE(Name ...string) (*ormPgsql, error) {
+	// 有参数使用传入的参数，否则使用default
+	if len(Name) == 0 {
+		Name = append(Name, "default")
 	}
 
-	// 可设置默认值配置项
-	if charsetErr != nil {
-		charset = "utf8mb4"
-	}
-	if maxIdleErr != nil {
-		maxIdle = 8
-	}
-	if maxConnErr != nil {
-		maxConn = 20
-	}
-	if maxLifetime == 0 { // 默认4个小时过期
-		maxLifetime = 4 * 60 * 60
+	// 获取指定配置和库名的句柄
+	handle, err := getConnectedHandle(Name[0], Name[1:]...)
+	if err != nil {
+		return nil, &UInitError{Name: Name[0], Err: err}
 	}
-
-	// 返回
-	return host, port, db, username, password, charset, maxIdle, maxConn, interpolateParams, maxLifetime, nil
+	return handle, nil
 }
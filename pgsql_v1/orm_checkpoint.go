@@ -0,0 +1,154 @@
+package pgsql_v1
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// UCheckpoint 结构体14：QueryAllCircle 的断点接口，每批数据回调完成后保存该批最后一行的排序字段值
+// 值为排序字段的text格式，和 UFastQuery 的排序字段一一对应
+type UCheckpoint interface {
+	Load(ctx context.Context) ([]string, error) // 读取最后保存的断点，没有时返回nil
+	Save(ctx context.Context, vals []string) error
+}
+
+// 断点的存储格式
+type checkpointData struct {
+	Vals    []string  `json:"vals"`
+	Updated time.Time `json:"updated"`
+}
+
+// UFileCheckpoint 结构体15：保存在文件中的断点，内容为json，先写临时文件再改名，保存过程中崩溃不会损坏原文件
+// 示例: Checkpoint: &pgsql_v1.UFileCheckpoint{Path: "/data/sync_demo.json"}
+type UFileCheckpoint struct {
+	Path string // 文件路径
+}
+
+// Load 读取断点，文件不存在时返回nil
+func (c *UFileCheckpoint) Load(ctx context.Context) ([]string, error) {
+	content, err := ioutil.ReadFile(c.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	data := checkpointData{}
+	if err := json.Unmarshal(content, &data); err != nil {
+		return nil, errors.New("断点文件格式错误: " + c.Path + ": " + err.Error())
+	}
+	return data.Vals, nil
+}
+
+// Save 保存断点
+func (c *UFileCheckpoint) Save(ctx context.Context, vals []string) error {
+	content, err := json.Marshal(checkpointData{Vals: vals, Updated: time.Now()})
+	if err != nil {
+		return err
+	}
+	// 写入临时文件并落盘后再改名，避免掉电时改名后的文件内容为空
+	tmp := c.Path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(content); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, c.Path); err != nil {
+		return err
+	}
+
+	// 目录落盘，保证改名本身在掉电后仍然有效
+	dir, err := os.Open(filepath.Dir(c.Path))
+	if err != nil {
+		return err
+	}
+	err = dir.Sync()
+	if closeErr := dir.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// UTableCheckpoint 结构体16：保存在数据表中的断点，通过 Handle().TableCheckpoint(...) 创建
+// 数据表不存在时自动创建：name text primary key, vals text(json), updated timestamptz
+type UTableCheckpoint struct {
+	orm     ormPgsql
+	table   string     // 断点表名，创建后加上双引号
+	name    string     // 断点名称，同一张表可保存多个任务的断点
+	created bool       // 是否已创建断点表
+	lock    sync.Mutex // 保护 table、created，Load/Save 可在多个goroutine中调用
+}
+
+// TableCheckpoint 创建保存在数据表中的断点，table为断点表名，name为任务名称
+// 示例: Checkpoint: Handle().TableCheckpoint("sync_checkpoint", "demo_to_es")
+func (Me ormPgsql) TableCheckpoint(table string, name string) *UTableCheckpoint {
+	return &UTableCheckpoint{orm: Me, table: table, name: name}
+}
+
+// Load 读取断点，没有记录时返回nil
+func (c *UTableCheckpoint) Load(ctx context.Context) ([]string, error) {
+	table, err := c.create(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := c.orm.WithScanMode(ScanString).queryMaps(ctx, "select vals from "+table+" where name = ?", []interface{}{c.name})
+	if err != nil || len(rows) == 0 {
+		return nil, err
+	}
+	vals := make([]string, 0)
+	if err := json.Unmarshal([]byte(rows[0]["vals"].(string)), &vals); err != nil {
+		return nil, errors.New("断点格式错误: " + c.name + ": " + err.Error())
+	}
+	return vals, nil
+}
+
+// Save 保存断点
+func (c *UTableCheckpoint) Save(ctx context.Context, vals []string) error {
+	table, err := c.create(ctx)
+	if err != nil {
+		return err
+	}
+	content, err := json.Marshal(vals)
+	if err != nil {
+		return err
+	}
+	_, err = c.orm.db().ExecContext(ctx, "insert into "+table+" (name, vals, updated) values ($1, $2, now())"+
+		" on conflict (name) do update set vals = excluded.vals, updated = excluded.updated", c.name, string(content))
+	return err
+}
+
+// 创建断点表，返回加上双引号的表名；创建失败时下次调用重试
+func (c *UTableCheckpoint) create(ctx context.Context) (string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.created {
+		return c.table, nil
+	}
+	if c.orm.initErr {
+		return "", errors.New("数据库未连接成功:" + c.orm.dbCfgName + " . " + c.orm.dbName)
+	}
+	table, err := UtilQuoteTable(c.table)
+	if err != nil {
+		return "", err
+	}
+	if _, err := c.orm.db().ExecContext(ctx, "create table if not exists "+table+
+		" (name text primary key, vals text not null, updated timestamptz not null default now())"); err != nil {
+		return "", err
+	}
+	c.table, c.created = table, true
+	return table, nil
+}
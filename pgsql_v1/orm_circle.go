@@ -0,0 +1,298 @@
+package pgsql_v1
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"strconv"
+	"strings"
+)
+
+// 排序字段值的别名前缀：排序字段转成text读取，作为下一批的起点，回调前删除
+const circleKeyPrefix = "pgsql_v1_k"
+
+// QueryAllCircle 分批读取的sql
+type circleSql struct {
+	orders    []string      // 排序字段，不带双引号
+	sql       string        // select ... from ... where 过滤条件，不含分批条件
+	from      string        // from ... where 过滤条件，用于统计范围
+	args      []interface{} // 过滤条件的参数
+	hasWhere  bool          // 是否有过滤条件
+	first     string        // 第一个排序字段，带双引号，并行读取时按该字段分块
+	rowExpr   string        // 分批条件左边的字段，多个字段时为 ("a","b")
+	compare   string        // 分批条件的比较符 > 或 <
+	order     string        // order by ... limit n
+	limit     int           // 单次读取行数
+	beginVals []interface{} // 起点，nil表示从头读取
+}
+
+// 生成一批的sql：起点为nil时从头读取，include为true时包含起点
+func (c circleSql) batch(beginVals []interface{}, include bool) (string, []interface{}) {
+	if beginVals == nil {
+		return c.sql + c.order, c.args
+	}
+
+	compare := c.compare
+	if include {
+		compare += "="
+	}
+	flags := strings.TrimSuffix(strings.Repeat("?,", len(beginVals)), ",")
+	if len(beginVals) > 1 {
+		flags = "(" + flags + ")"
+	}
+	KeySql := c.sql
+	if c.hasWhere {
+		KeySql += " and "
+	} else {
+		KeySql += " where "
+	}
+	KeySql += c.rowExpr + " " + compare + " " + flags + c.order
+	return KeySql, append(append([]interface{}{}, c.args...), beginVals...)
+}
+
+// 限定第一个排序字段的范围 lo <= a < hi，lo、hi为nil时不限定该边界
+func (c circleSql) withRange(lo, hi interface{}) circleSql {
+	conds := make([]string, 0, 2)
+	args := append([]interface{}{}, c.args...)
+	if lo != nil {
+		conds = append(conds, c.first+" >= ?")
+		args = append(args, lo)
+	}
+	if hi != nil {
+		conds = append(conds, c.first+" < ?")
+		args = append(args, hi)
+	}
+	if len(conds) == 0 {
+		return c
+	}
+	if c.hasWhere {
+		c.sql += " and "
+	} else {
+		c.sql += " where "
+	}
+	c.sql += strings.Join(conds, " and ")
+	c.args = args
+	c.hasWhere = true
+	return c
+}
+
+// 读取一行中排序字段的值，作为下一批的起点
+func (c circleSql) lastVals(row map[string]interface{}) []interface{} {
+	vals := make([]interface{}, len(c.orders))
+	for i := range c.orders {
+		vals[i] = row[circleKeyPrefix+strconv.Itoa(i)]
+	}
+	return vals
+}
+
+// 删除一行中排序字段值的别名列
+func (c circleSql) strip(row map[string]interface{}) {
+	for i := range c.orders {
+		delete(row, circleKeyPrefix+strconv.Itoa(i))
+	}
+}
+
+// 分批读取数据，每批调用一次batchFunc，返回ErrStop时停止并返回nil，返回其他错误时停止并返回该错误；
+// beginVals为nil时从头读取，include为true时包含起点；checkpoint不为nil时每批回调完成后保存该批最后一行的排序字段值
+func (Me ormPgsql) circleScanCtx(ctx context.Context, c circleSql, beginVals []interface{}, include bool, checkpoint UCheckpoint, batchFunc func(rows []map[string]interface{}) error) error {
+	for {
+		// 1、ctx已取消则终止
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		// 2、读取一批
+		KeySql, KeyArgs := c.batch(beginVals, include)
+		maps, err := Me.queryMaps(ctx, KeySql, KeyArgs)
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+		include = false
+		rowNum := len(maps)
+		if rowNum == 0 {
+			return nil
+		}
+
+		// 3、刷新起点为最后一行的排序字段值
+		beginVals = c.lastVals(maps[rowNum-1])
+		for _, v := range maps {
+			c.strip(v)
+		}
+
+		// 4、数据回调
+		if err := batchFunc(maps); err != nil {
+			if err == ErrStop {
+				return nil
+			}
+			log.Error(err)
+			return err
+		}
+
+		// 5、保存断点
+		if checkpoint != nil {
+			if err := checkpoint.Save(ctx, utilCheckpointVals(beginVals)); err != nil {
+				log.Error(err)
+				return err
+			}
+		}
+
+		if rowNum < c.limit {
+			return nil
+		}
+	}
+}
+
+// 估算数据表的总行数(pg_class.reltuples)，未统计过时返回-1
+func (Me ormPgsql) estimateRowsCtx(ctx context.Context, table string) (int64, error) {
+	quoted, err := UtilQuoteTable(table)
+	if err != nil {
+		return 0, err
+	}
+	res, err := Me.WithScanMode(ScanTyped).QueryCtx(ctx, "select reltuples::bigint as num from pg_class where oid = :table::regclass",
+		map[string]interface{}{"table": quoted})
+	if err != nil {
+		return 0, err
+	}
+	if len(res) > 0 {
+		if num, ok := res[0]["num"].(int64); ok && num >= 0 {
+			return num, nil
+		}
+	}
+	return -1, nil
+}
+
+// 辅助函数: 逐行回调转换成每批回调，backFunc返回false时返回ErrStop
+func utilCircleRows(backFunc func(V map[string]interface{}) bool) func(rows []map[string]interface{}) error {
+	return func(rows []map[string]interface{}) error {
+		for _, v := range rows {
+			if !backFunc(v) {
+				return ErrStop
+			}
+		}
+		return nil
+	}
+}
+
+// 辅助函数: 排序字段值转换成断点，值为排序字段的text格式
+func utilCheckpointVals(vals []interface{}) []string {
+	ret := make([]string, len(vals))
+	for i, v := range vals {
+		if str, ok := v.(string); ok {
+			ret[i] = str
+		} else if v != nil {
+			ret[i] = fmt.Sprint(v)
+		}
+	}
+	return ret
+}
+
+// 检查排序字段包含主键或某个唯一索引的全部字段，保证排序唯一
+func (Me ormPgsql) checkUniqueOrderCtx(ctx context.Context, table string, orders []string) error {
+	keys, err := Me.DescUniqueKeysCtx(ctx, table)
+	if err != nil {
+		return err
+	}
+	isOrder := map[string]bool{}
+	for _, v := range orders {
+		isOrder[v] = true
+	}
+	for _, key := range keys {
+		all := true
+		for _, col := range key {
+			if !isOrder[col] {
+				all = false
+				break
+			}
+		}
+		if all {
+			return nil
+		}
+	}
+	return errors.New(strings.Join(orders, ",") + " 不包含 " + table + " 的主键或唯一索引")
+}
+
+// 辅助函数: 根据UFastQuery生成分批读取的sql
+func utilCircleSql(Cfg UFastQuery) (circleSql, error) {
+	c := circleSql{limit: Cfg.RowLimit}
+	if c.limit <= 0 {
+		c.limit = 1000
+	}
+
+	// 1、排序字段和顺序
+	orders := Cfg.OrderFields
+	if len(orders) == 0 && Cfg.PriField != "" {
+		orders = []string{Cfg.PriField}
+	}
+	if len(orders) == 0 {
+		return c, errors.New("没有设置排序字段")
+	}
+	sort := strings.ToLower(strings.TrimSpace(Cfg.PriSort))
+	switch sort {
+	case "", "asc":
+		sort, c.compare = "asc", ">"
+	case "desc":
+		c.compare = "<"
+	default:
+		return c, errors.New("PriSort 只能是 asc 或 desc: " + Cfg.PriSort)
+	}
+
+	// 2、排序字段加上双引号；读取字段后追加排序字段和转成text的排序字段值
+	quoted := make([]string, len(orders))
+	keys := make([]string, len(orders))
+	sorts := make([]string, len(orders))
+	for i, v := range orders {
+		field, err := UtilQuoteIdent(v)
+		if err != nil {
+			return c, err
+		}
+		quoted[i] = field
+		keys[i] = field + "::text as " + circleKeyPrefix + strconv.Itoa(i)
+		sorts[i] = field + " " + sort
+		c.orders = append(c.orders, utilUnquoteIdent(v))
+	}
+	c.first = quoted[0]
+	c.rowExpr = quoted[0]
+	if len(quoted) > 1 {
+		c.rowExpr = "(" + strings.Join(quoted, ",") + ")"
+	}
+	c.order = " order by " + strings.Join(sorts, ",") + " limit " + strconv.Itoa(c.limit)
+
+	// 3、select ... from ... where
+	table, err := UtilQuoteTable(Cfg.Table)
+	if err != nil {
+		return c, err
+	}
+	fields := Cfg.Fields
+	if strings.TrimSpace(fields) == "" {
+		fields = "*"
+	}
+	c.from = " from " + table
+	if len(Cfg.Where) > 0 {
+		where, args, err := utilBuildWhere(Cfg.Where)
+		if err != nil {
+			return c, err
+		}
+		c.from += " where (" + where + ")"
+		c.args = args
+		c.hasWhere = true
+	}
+	c.sql = "select " + fields + "," + strings.Join(quoted, ",") + "," + strings.Join(keys, ",") + c.from
+
+	// 4、起点
+	switch {
+	case len(Cfg.BeginVals) > 0:
+		if len(Cfg.BeginVals) != len(orders) {
+			return c, errors.New("BeginVals 的个数必须和排序字段一致")
+		}
+		c.beginVals = Cfg.BeginVals
+	case Cfg.BeginVal != nil:
+		if len(orders) > 1 {
+			return c, errors.New("多个排序字段时使用 BeginVals 设置起点")
+		}
+		c.beginVals = []interface{}{Cfg.BeginVal}
+	}
+
+	return c, nil
+}
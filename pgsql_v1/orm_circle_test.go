@@ -0,0 +1,94 @@
+package pgsql_v1
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUtilCircleSql(t *testing.T) {
+	check := func(name, gotSql string, gotArgs []interface{}, wantSql string, wantArgs ...interface{}) {
+		t.Helper()
+		if gotSql != wantSql {
+			t.Errorf("%s sql:\n got %q\nwant %q", name, gotSql, wantSql)
+		}
+		if len(gotArgs) != 0 || len(wantArgs) != 0 {
+			if !reflect.DeepEqual(gotArgs, wantArgs) {
+				t.Errorf("%s args = %v, want %v", name, gotArgs, wantArgs)
+			}
+		}
+	}
+
+	// 单个排序字段，默认asc，从头读取
+	c, err := utilCircleSql(UFastQuery{Table: "demo", PriField: "id", BeginVal: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	head := `select *,"id","id"::text as pgsql_v1_k0 from "demo"`
+	sql, args := c.batch(nil, false)
+	check("head", sql, args, head+` order by "id" asc limit 1000`)
+	sql, args = c.batch(c.beginVals, false)
+	check("begin", sql, args, head+` where "id" > ? order by "id" asc limit 1000`, 3)
+
+	// 多个排序字段，desc，有过滤条件：断点续读用行值比较，过滤条件的参数在前
+	c, err = utilCircleSql(UFastQuery{Table: "public.demo", Fields: "id,name", OrderFields: []string{"updated", `"id"`},
+		PriSort: " DESC ", Where: map[string]interface{}{"status": 1}, RowLimit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	head = `select id,name,"updated","id","updated"::text as pgsql_v1_k0,"id"::text as pgsql_v1_k1 from "public"."demo" where ("status" = ?)`
+	order := ` order by "updated" desc,"id" desc limit 10`
+	sql, args = c.batch([]interface{}{"2024-01-01", "5"}, false)
+	check("resume", sql, args, head+` and ("updated","id") < (?,?)`+order, 1, "2024-01-01", "5")
+	sql, args = c.batch([]interface{}{"2024-01-01", "5"}, true)
+	check("resume include", sql, args, head+` and ("updated","id") <= (?,?)`+order, 1, "2024-01-01", "5")
+	if !reflect.DeepEqual(c.orders, []string{"updated", "id"}) {
+		t.Errorf("orders = %v, want [updated id]", c.orders)
+	}
+
+	// 并行分块：限定第一个排序字段的范围，原sql不变
+	r := c.withRange(10, nil)
+	sql, args = r.batch(nil, false)
+	check("range", sql, args, head+` and "updated" >= ?`+order, 1, 10)
+	if sql, _ = c.batch(nil, false); sql != head+order {
+		t.Errorf("withRange modified the original sql: %q", sql)
+	}
+
+	// 断点值：读取排序字段的别名列，回调前删除
+	row := map[string]interface{}{"id": 5, "pgsql_v1_k0": "2024-01-01", "pgsql_v1_k1": "5"}
+	if got := c.lastVals(row); !reflect.DeepEqual(got, []interface{}{"2024-01-01", "5"}) {
+		t.Errorf("lastVals = %v", got)
+	}
+	c.strip(row)
+	if len(row) != 1 {
+		t.Errorf("strip left %v", row)
+	}
+
+	for name, cfg := range map[string]UFastQuery{
+		"no order":       {Table: "demo"},
+		"bad sort":       {Table: "demo", PriField: "id", PriSort: "asc;drop"},
+		"bad table":      {Table: "a.b.c", PriField: "id"},
+		"beginVals size": {Table: "demo", OrderFields: []string{"a", "b"}, BeginVals: []interface{}{1}},
+		"beginVal multi": {Table: "demo", OrderFields: []string{"a", "b"}, BeginVal: 1},
+	} {
+		if _, err := utilCircleSql(cfg); err == nil {
+			t.Errorf("%s: want error", name)
+		}
+	}
+}
+
+func TestUtilCircleRows(t *testing.T) {
+	seen := 0
+	fn := utilCircleRows(func(V map[string]interface{}) bool {
+		seen++
+		return V["id"] != 2
+	})
+	if err := fn([]map[string]interface{}{{"id": 0}, {"id": 1}}); err != nil || seen != 2 {
+		t.Fatalf("first batch: err = %v, seen = %d", err, seen)
+	}
+	if err := fn([]map[string]interface{}{{"id": 2}, {"id": 3}}); err != ErrStop {
+		t.Errorf("err = %v, want ErrStop", err)
+	}
+	if seen != 3 {
+		t.Errorf("rows after stop were called back: seen = %d, want 3", seen)
+	}
+}
@@ -0,0 +1,125 @@
+package pgsql_v1
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUtilEnvConfigNames(t *testing.T) {
+	cases := []struct {
+		environ []string
+		want    map[string]bool
+	}{
+		{[]string{"PG_URL=postgres://u@h/d"}, map[string]bool{"default": true}},
+		{[]string{"PG_HOST=h", "PG_DEFAULT_MAXCONN=50"}, map[string]bool{"default": true}},
+		{[]string{"PG_ORDER_HOST=h", "PG_ORDER_USERNAME=u"}, map[string]bool{"order": true}},
+		{[]string{"PG_ORDER_LOG_CONNECT_TIMEOUT=3", "PG_A_SEARCH_PATH=s"}, map[string]bool{"order_log": true, "a": true}},
+		{[]string{"PGDATA=/data", "PG_UNKNOWN=1", "HOME=/root", "PG_ORDER_PORTS=1"}, map[string]bool{}},
+	}
+	for _, c := range cases {
+		if got := utilEnvConfigNames("PG_", c.environ); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("utilEnvConfigNames(%v) = %v, want %v", c.environ, got, c.want)
+		}
+	}
+}
+
+func TestUtilConfigFromLookup(t *testing.T) {
+	cases := []struct {
+		values  map[string]string
+		want    Config
+		wantErr bool
+	}{
+		{map[string]string{"host": "h", "username": "u", "maxConn": " 50 ", "lazy": "yes"},
+			Config{Host: "h", Username: "u", MaxConn: 50, Lazy: true}, false},
+		{map[string]string{"url": "postgres://u:p@h:6432/d?maxIdle=3", "host": "h2", "replicas": "r1, r2:5433,"},
+			Config{Host: "h2", Port: "6432", Db: "d", Username: "u", Password: "p", MaxIdle: 3, Replicas: []string{"r1", "r2:5433"}}, false},
+		{map[string]string{"url": ""}, Config{}, true},
+		{map[string]string{"host": "h"}, Config{}, true},
+		{map[string]string{"host": "h", "username": "u", "maxConn": "x"}, Config{}, true},
+		{map[string]string{"host": "h", "username": "u", "optional": "maybe"}, Config{}, true},
+		{map[string]string{"host": "h", "username": "u", "scanMode": "bytes"}, Config{}, true},
+		{map[string]string{"host": "h", "username": "u", "charset": "gbk"}, Config{}, true},
+		{map[string]string{"host": "h", "username": "u", "balance": "random"}, Config{}, true},
+		{map[string]string{"url": "mysql://u@h/d"}, Config{}, true},
+	}
+	for _, c := range cases {
+		got, err := utilConfigFromLookup(func(key string) (string, bool) {
+			v, ok := c.values[key]
+			return v, ok
+		})
+		if (err != nil) != c.wantErr {
+			t.Errorf("utilConfigFromLookup(%v) err = %v, wantErr %v", c.values, err, c.wantErr)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(got, c.want) {
+			t.Errorf("utilConfigFromLookup(%v) = %+v, want %+v", c.values, got, c.want)
+		}
+	}
+}
+
+func TestConfigDsn(t *testing.T) {
+	cases := []struct {
+		c    Config
+		db   string
+		want string
+	}{
+		{Config{Host: "h", Port: "5432", Username: "u", Password: "p", Charset: "utf8mb4", SslMode: "disable"}, "d",
+			"host='h' port='5432' user='u' password='p' dbname='d' client_encoding='UTF8' sslmode='disable'"},
+		{Config{Host: "h", Username: "u", Password: `it's\x`}, "",
+			`host='h' user='u' password='it\'s\\x' client_encoding='UTF8'`},
+		{Config{Host: "h", Username: "u", SslMode: "verify-full", SslRootCert: "/ca.pem", ConnectTimeout: 5, ApplicationName: "app",
+			SearchPath: "s1,public", Timezone: "Asia/Shanghai", Options: map[string]string{"statement_timeout": "5000", "lock_timeout": "1s"}}, "d",
+			"host='h' user='u' dbname='d' client_encoding='UTF8' sslmode='verify-full' sslrootcert='/ca.pem' application_name='app'" +
+				" search_path='s1,public' timezone='Asia/Shanghai' connect_timeout='5' lock_timeout='1s' statement_timeout='5000'"},
+	}
+	for _, c := range cases {
+		if got := c.c.dsn(c.db); got != c.want {
+			t.Errorf("dsn(%q) = %q, want %q", c.db, got, c.want)
+		}
+	}
+}
+
+func TestUtilQuoteDsnValue(t *testing.T) {
+	cases := [][2]string{
+		{"", "''"},
+		{"abc", "'abc'"},
+		{"a b", "'a b'"},
+		{"it's", `'it\'s'`},
+		{`a\b`, `'a\\b'`},
+		{`\'`, `'\\\''`},
+	}
+	for _, c := range cases {
+		if got := utilQuoteDsnValue(c[0]); got != c[1] {
+			t.Errorf("utilQuoteDsnValue(%q) = %q, want %q", c[0], got, c[1])
+		}
+	}
+}
+
+func TestUtilParseDSN(t *testing.T) {
+	cases := []struct {
+		dsn     string
+		want    Config
+		wantErr bool
+	}{
+		{"postgres://u:p%40ss@h:6432/d", Config{Host: "h", Port: "6432", Db: "d", Username: "u", Password: "p@ss"}, false},
+		{"postgresql://u@[::1]/d?sslmode=require&maxConn=30&scanMode=typed",
+			Config{Host: "::1", Db: "d", Username: "u", SslMode: "require", MaxConn: 30, ScanMode: ScanTyped}, false},
+		{"postgres://u@h/d?statement_timeout=5000&application_name=app&dbname=d2",
+			Config{Host: "h", Db: "d2", Username: "u", ApplicationName: "app", Options: map[string]string{"statement_timeout": "5000"}}, false},
+		{"postgres://u@h/d?replicas=r1,r2:5433&balance=least_conn",
+			Config{Host: "h", Db: "d", Username: "u", Replicas: []string{"r1", "r2:5433"}, Balance: BalanceLeastConn}, false},
+		{"mysql://u@h/d", Config{}, true},
+		{"postgres://u@h/d?maxConn=x", Config{}, true},
+		{"postgres://u@h:port/d", Config{}, true},
+	}
+	for _, c := range cases {
+		got, err := utilParseDSN(c.dsn)
+		if (err != nil) != c.wantErr {
+			t.Errorf("utilParseDSN(%q) err = %v, wantErr %v", c.dsn, err, c.wantErr)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(got, c.want) {
+			t.Errorf("utilParseDSN(%q) = %+v, want %+v", c.dsn, got, c.want)
+		}
+	}
+}
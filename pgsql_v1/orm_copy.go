@@ -0,0 +1,248 @@
+package pgsql_v1
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// COPY出错信息中的行号，如 "COPY demo, line 3, column status: ..."
+var copyLineRegexp = regexp.MustCompile(`COPY .*, line (\d+)`)
+
+// UCopyRows 结构体4：COPY批量写入的行迭代函数，每次返回一行数据(顺序和columns一致)，ok为false表示结束
+type UCopyRows func() (row []interface{}, ok bool, err error)
+
+// UCopyError 结构体5：COPY批量写入出错信息
+type UCopyError struct {
+	Row int64 // 出错行的序号，从0开始；-1表示无法确定具体行
+	Err error // 原始错误
+}
+
+func (e *UCopyError) Error() string {
+	if e.Row < 0 {
+		return "COPY写入失败: " + e.Err.Error()
+	}
+	return "COPY写入失败，第" + strconv.FormatInt(e.Row, 10) + "行: " + e.Err.Error()
+}
+
+func (e *UCopyError) Unwrap() error {
+	return e.Err
+}
+
+// CopyIn 批量写入1：使用COPY协议批量写入数据，速度远高于逐条insert，适合大批量数据导入
+// rows 支持：
+//
+//	[]map[string]interface{}  columns为空时使用第一行的键名，每行的键名必须一致
+//	结构体切片 []T 或 []*T     columns为空时使用 db 标签对应的全部字段
+//	UCopyRows 迭代函数         columns必须指定
+//
+// 示例:
+//
+//	num, err := CopyIn("user", []string{"user_id", "user_name"}, []map[string]interface{}{
+//	    {"user_id": 1, "user_name": "张三"},
+//	})
+//
+// 说明：在事务中执行，出错全部回滚，返回 *UCopyError 包含出错行序号；已处于事务中时使用保存点
+func (Me ormPgsql) CopyIn(table string, columns []string, rows interface{}) (int64, error) {
+	return Me.CopyInCtx(context.Background(), table, columns, rows)
+}
+
+// CopyInCtx 批量写入1：使用COPY协议批量写入数据，可通过ctx取消或设置超时
+func (Me ormPgsql) CopyInCtx(ctx context.Context, table string, columns []string, rows interface{}) (int64, error) {
+	if Me.initErr {
+		log.Error("数据库未连接成功", Me.dbCfgName, Me.dbName)
+		return 0, errors.New("数据库未连接成功:" + Me.dbCfgName + " . " + Me.dbName)
+	}
+
+	// 1、行数据统一成迭代函数
+	columns, next, err := utilCopyRows(columns, rows)
+	if err != nil {
+		log.Error(err)
+		return 0, err
+	}
+	if len(columns) == 0 {
+		return 0, nil
+	}
+
+	// 2、生成COPY语句
+	copySql, err := utilCopyInSql(table, columns)
+	if err != nil {
+		log.Error(err)
+		return 0, err
+	}
+
+	// 3、在事务中写入
+	num := int64(0)
+	err = Me.TransactionCtx(ctx, func(tx *Tx) error {
+		stmt, err := tx.GetTx().PrepareContext(ctx, copySql)
+		if err != nil {
+			return &UCopyError{Row: -1, Err: err}
+		}
+		defer func() { _ = stmt.Close() }()
+
+		// 3.1、逐行写入
+		for {
+			row, ok, err := next()
+			if err != nil {
+				return &UCopyError{Row: num, Err: err}
+			}
+			if !ok {
+				break
+			}
+			if len(row) != len(columns) {
+				return &UCopyError{Row: num, Err: fmt.Errorf("数据列数%d和字段数%d不一致", len(row), len(columns))}
+			}
+			if _, err := stmt.ExecContext(ctx, row...); err != nil {
+				return utilCopyError(err, num)
+			}
+			num++
+		}
+
+		// 3.2、结束写入，服务端在此时返回数据错误
+		if _, err := stmt.ExecContext(ctx); err != nil {
+			return utilCopyError(err, -1)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error(err)
+		return 0, err
+	}
+
+	return num, nil
+}
+
+// 辅助函数: 生成COPY语句，表名和字段名做合法性检查
+func utilCopyInSql(table string, columns []string) (string, error) {
+	parts, err := utilSplitTable(table)
+	if err != nil {
+		return "", err
+	}
+	for _, v := range append(append([]string{}, parts...), columns...) {
+		if _, err := UtilQuoteIdent(v); err != nil {
+			return "", err
+		}
+	}
+	names := make([]string, len(columns))
+	for i, v := range columns {
+		names[i] = utilUnquoteIdent(v)
+	}
+	if len(parts) == 2 {
+		return pq.CopyInSchema(utilUnquoteIdent(parts[0]), utilUnquoteIdent(parts[1]), names...), nil
+	}
+	return pq.CopyIn(utilUnquoteIdent(parts[0]), names...), nil
+}
+
+// 辅助函数: 把服务端错误转换成 *UCopyError，错误信息中有行号时使用该行号
+// COPY数据异步发送，服务端报错时可能已写入了后面的行，所以优先使用服务端返回的行号
+func utilCopyError(err error, row int64) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		if m := copyLineRegexp.FindStringSubmatch(pqErr.Where); m != nil {
+			if line, e := strconv.ParseInt(m[1], 10, 64); e == nil {
+				row = line - 1
+			}
+		}
+	}
+	return &UCopyError{Row: row, Err: err}
+}
+
+// 辅助函数: 各种格式的行数据统一转换成迭代函数，返回实际使用的字段名
+func utilCopyRows(columns []string, rows interface{}) ([]string, UCopyRows, error) {
+	switch inst := rows.(type) {
+	// 迭代函数
+	case UCopyRows:
+		if len(columns) == 0 {
+			return nil, nil, errors.New("使用迭代函数时必须指定columns")
+		}
+		return columns, inst, nil
+	case func() ([]interface{}, bool, error):
+		if len(columns) == 0 {
+			return nil, nil, errors.New("使用迭代函数时必须指定columns")
+		}
+		return columns, inst, nil
+
+	// map切片
+	case []map[string]interface{}:
+		if len(inst) == 0 {
+			return nil, nil, nil
+		}
+		strict := len(columns) == 0
+		if strict {
+			columns = utilSortedKeys(inst[0])
+		}
+		i := 0
+		return columns, func() ([]interface{}, bool, error) {
+			if i >= len(inst) {
+				return nil, false, nil
+			}
+			m := inst[i]
+			i++
+			if strict && len(m) != len(columns) {
+				return nil, false, errors.New("字段和第一行不一致")
+			}
+			row := make([]interface{}, len(columns))
+			for k, col := range columns {
+				v, ok := m[col]
+				if !ok {
+					return nil, false, errors.New("缺少字段: " + col)
+				}
+				row[k] = v
+			}
+			return row, true, nil
+		}, nil
+	}
+
+	// 结构体切片
+	rv := reflect.ValueOf(rows)
+	if rv.Kind() != reflect.Slice {
+		return nil, nil, fmt.Errorf("不支持的数据类型: %T", rows)
+	}
+	elemType := rv.Type().Elem()
+	if elemType.Kind() == reflect.Ptr {
+		elemType = elemType.Elem()
+	}
+	if elemType.Kind() != reflect.Struct {
+		return nil, nil, fmt.Errorf("不支持的数据类型: %T", rows)
+	}
+	if rv.Len() == 0 {
+		return nil, nil, nil
+	}
+	fields := utilStructFields(elemType)
+	if len(columns) == 0 {
+		for k := range fields {
+			columns = append(columns, k)
+		}
+		sort.Strings(columns)
+	}
+	for _, col := range columns {
+		if _, ok := fields[col]; !ok {
+			return nil, nil, fmt.Errorf("%s 没有字段对应 %s", elemType.String(), col)
+		}
+	}
+	i := 0
+	return columns, func() ([]interface{}, bool, error) {
+		if i >= rv.Len() {
+			return nil, false, nil
+		}
+		elem := rv.Index(i)
+		i++
+		if elem.Kind() == reflect.Ptr {
+			if elem.IsNil() {
+				return nil, false, errors.New("数据为nil")
+			}
+			elem = elem.Elem()
+		}
+		row := make([]interface{}, len(columns))
+		for k, col := range columns {
+			row[k] = elem.FieldByIndex(fields[col]).Interface()
+		}
+		return row, true, nil
+	}, nil
+}
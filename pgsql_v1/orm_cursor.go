@@ -0,0 +1,118 @@
+package pgsql_v1
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"strconv"
+)
+
+// 游标每次读取的默认行数
+const cursorFetchSize = 1000
+
+// QueryCursor 特殊6：使用服务端游标遍历任意查询的全部数据，每行数据调用一次fn
+// sql 和 conditions 同 Query 的sql和条件参数；fetchSize 为每次 FETCH 的行数，<=0 时为1000
+// 示例:
+//
+//	err := QueryCursor("select d.*, u.name from demo d join users u on u.id = d.creator where d.status=:status",
+//	    map[string]interface{}{"status": 1}, 500,
+//	    func(row map[string]interface{}) error {
+//	        return nil // 返回 pgsql_v1.ErrStop 停止遍历，返回其他错误时停止并返回该错误
+//	    },
+//	)
+//
+// 说明：在 REPEATABLE READ 只读事务中执行 DECLARE ... CURSOR / FETCH，内存占用固定，所有数据来自同一快照；
+// 适合联表、视图等 QueryAllCircle 无法按主键分页的查询。已在事务中时使用该事务，快照由外层事务的隔离级别决定
+func (Me ormPgsql) QueryCursor(sql string, conditions map[string]interface{}, fetchSize int, fn func(row map[string]interface{}) error) error {
+	return Me.QueryCursorCtx(context.Background(), sql, conditions, fetchSize, fn)
+}
+
+// QueryCursorCtx 特殊6：使用服务端游标遍历任意查询的全部数据，可通过ctx取消或设置超时
+func (Me ormPgsql) QueryCursorCtx(ctx context.Context, sql string, conditions map[string]interface{}, fetchSize int, fn func(row map[string]interface{}) error) error {
+	if Me.initErr {
+		log.Error("数据库未连接成功", Me.dbCfgName, Me.dbName)
+		return errors.New("数据库未连接成功:" + Me.dbCfgName + " . " + Me.dbName)
+	}
+	if fetchSize <= 0 {
+		fetchSize = cursorFetchSize
+	}
+
+	// 1、sql拼凑
+	KeySql, KeyArgs, err := utilQuerySql(sql, []map[string]interface{}{conditions})
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+
+	// 2、在快照一致的只读事务中遍历，出错时回滚自动关闭游标
+	err = Me.TransactionOptCtx(ctx, &sqlTxSnapshot, func(tx *Tx) error {
+		return tx.cursorCtx(ctx, KeySql, KeyArgs, fetchSize, fn)
+	})
+	if err == ErrStop {
+		return nil
+	}
+	if err != nil {
+		log.Error(err)
+	}
+	return err
+}
+
+// 快照一致的只读事务选项
+var sqlTxSnapshot = sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true}
+
+// 在事务中声明游标并分批读取，fn返回ErrStop时原样返回
+func (Me Tx) cursorCtx(ctx context.Context, KeySql string, KeyArgs []interface{}, fetchSize int, fn func(row map[string]interface{}) error) error {
+	// 1、声明游标
+	Me.tx.cursor++
+	name := "pgsql_v1_cur_" + strconv.Itoa(Me.tx.cursor)
+	KeySql, err := UtilFormatExecE(KeySql)
+	if err != nil {
+		return err
+	}
+	if _, err := Me.db().ExecContext(ctx, "declare "+name+" no scroll cursor for "+KeySql, KeyArgs...); err != nil {
+		return err
+	}
+
+	// 2、分批读取
+	fetchSql := "fetch forward " + strconv.Itoa(fetchSize) + " from " + name
+	total := int64(0)
+	for {
+		// 2.1、ctx已取消则终止
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		// 2.2、读取一批，逐行回调
+		rows, err := Me.queryIter(ctx, fetchSql, nil)
+		if err != nil {
+			return err
+		}
+		rows.row = total - 1 // 行序号从已读取的总行数开始
+		num := 0
+		for rows.Next() {
+			num++
+			row, err := rows.Map()
+			if err == nil {
+				err = fn(row)
+			}
+			if err != nil {
+				_ = rows.Close()
+				return err
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		total += int64(num)
+
+		// 2.3、不足一批说明已读完
+		if num < fetchSize {
+			break
+		}
+	}
+
+	// 3、关闭游标
+	_, err = Me.db().ExecContext(ctx, "close "+name)
+	return err
+}
@@ -0,0 +1,264 @@
+package pgsql_v1
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"reflect"
+	"strconv"
+)
+
+// ErrStop QueryEach、QueryEachInto 的回调函数返回该错误时停止遍历，QueryEach 返回nil
+var ErrStop = errors.New("停止遍历")
+
+// URows 结构体11：逐行读取的结果集，通过 QueryIter、QueryTableIter 创建，数据不会一次性读入内存
+// 示例:
+//
+//	rows, err := QueryIter("select * from demo where status=:status", map[string]interface{}{"status": 1})
+//	if err != nil { ... }
+//	defer rows.Close()
+//	for rows.Next() {
+//	    row, err := rows.Map()
+//	    if err != nil { ... }
+//	}
+//	if err := rows.Err(); err != nil { ... }
+//
+// 说明：用完必须调用Close释放连接；在事务中使用时，关闭前不能在同一事务中执行其他语句
+type URows struct {
+	list     *sql.Rows
+	columns  []string
+	types    []*sql.ColumnType // 按列类型返回时的列类型，否则为nil
+	fields   map[reflect.Type]map[string][]int
+	row      int64 // 当前行序号，从0开始，未调用Next时为-1
+	err      error
+	closeErr error
+	closed   bool
+}
+
+// UScanError 结构体12：逐行读取时的数据转换错误
+type UScanError struct {
+	Row int64 // 出错行的序号，从0开始
+	Err error // 原始错误
+}
+
+func (e *UScanError) Error() string {
+	return "第" + strconv.FormatInt(e.Row, 10) + "行读取失败: " + e.Err.Error()
+}
+
+func (e *UScanError) Unwrap() error {
+	return e.Err
+}
+
+// QueryIter 逐行读取1： 常规读取(格式化sql)，返回逐行读取的结果集，参数同Query
+func (Me ormPgsql) QueryIter(sql string, ConOpt ...map[string]interface{}) (*URows, error) {
+	return Me.QueryIterCtx(context.Background(), sql, ConOpt...)
+}
+
+// QueryIterCtx 逐行读取1： 常规读取(格式化sql)，返回逐行读取的结果集，可通过ctx取消或设置超时
+func (Me ormPgsql) QueryIterCtx(ctx context.Context, sql string, ConOpt ...map[string]interface{}) (*URows, error) {
+	if Me.initErr {
+		log.Error("数据库未连接成功", Me.dbCfgName, Me.dbName)
+		return nil, errors.New("数据库未连接成功:" + Me.dbCfgName + " . " + Me.dbName)
+	}
+
+	KeySql, KeyArgs, err := utilQuerySql(sql, ConOpt)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return Me.reader(utilHasLock(ConOpt) || !utilSqlReadOnly(KeySql)).queryIter(ctx, KeySql, KeyArgs)
+}
+
+// QueryTableIter 逐行读取2： 指定数据表读取，返回逐行读取的结果集，参数同QueryTable
+func (Me ormPgsql) QueryTableIter(table string, fields string, ConOpt ...map[string]interface{}) (*URows, error) {
+	return Me.QueryTableIterCtx(context.Background(), table, fields, ConOpt...)
+}
+
+// QueryTableIterCtx 逐行读取2： 指定数据表读取，返回逐行读取的结果集，可通过ctx取消或设置超时
+func (Me ormPgsql) QueryTableIterCtx(ctx context.Context, table string, fields string, ConOpt ...map[string]interface{}) (*URows, error) {
+	if Me.initErr {
+		log.Error("数据库未连接成功", Me.dbCfgName, Me.dbName)
+		return nil, errors.New("数据库未连接成功:" + Me.dbCfgName + " . " + Me.dbName)
+	}
+
+	KeySql, KeyArgs, err := utilQueryTableSql(table, fields, ConOpt)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return Me.reader(utilHasLock(ConOpt) || !utilSqlReadOnly(KeySql)).queryIter(ctx, KeySql, KeyArgs)
+}
+
+// QueryEach 逐行读取3： 常规读取(格式化sql)，每行数据调用一次fn，参数同Query
+// 示例:
+//
+//	err := QueryEach("select * from demo", func(row map[string]interface{}) error {
+//	    return nil // 返回 pgsql_v1.ErrStop 停止遍历，返回其他错误时停止并返回该错误
+//	})
+//
+// 说明：数据转换出错时返回 *UScanError，包含出错行序号
+func (Me ormPgsql) QueryEach(sql string, fn func(row map[string]interface{}) error, ConOpt ...map[string]interface{}) error {
+	return Me.QueryEachCtx(context.Background(), sql, fn, ConOpt...)
+}
+
+// QueryEachCtx 逐行读取3： 常规读取(格式化sql)，每行数据调用一次fn，可通过ctx取消或设置超时
+func (Me ormPgsql) QueryEachCtx(ctx context.Context, sql string, fn func(row map[string]interface{}) error, ConOpt ...map[string]interface{}) error {
+	rows, err := Me.QueryIterCtx(ctx, sql, ConOpt...)
+	if err != nil {
+		return err
+	}
+	return rows.each(func() error {
+		row, err := rows.Map()
+		if err != nil {
+			return err
+		}
+		return fn(row)
+	})
+}
+
+// QueryEachInto 逐行读取4： 常规读取(格式化sql)，每行数据写入结构体dest后调用一次fn，参数同Query
+// 示例:
+//
+//	var user User
+//	err := QueryEachInto(&user, "select * from user", func() error {
+//	    fmt.Println(user.Id, user.Name)
+//	    return nil
+//	})
+//
+// 说明：dest为结构体指针，每行写入前清零；字段对应规则同QueryInto
+func (Me ormPgsql) QueryEachInto(dest interface{}, sql string, fn func() error, ConOpt ...map[string]interface{}) error {
+	return Me.QueryEachIntoCtx(context.Background(), dest, sql, fn, ConOpt...)
+}
+
+// QueryEachIntoCtx 逐行读取4： 常规读取(格式化sql)，每行数据写入结构体dest后调用一次fn，可通过ctx取消或设置超时
+func (Me ormPgsql) QueryEachIntoCtx(ctx context.Context, dest interface{}, sql string, fn func() error, ConOpt ...map[string]interface{}) error {
+	rows, err := Me.QueryIterCtx(ctx, sql, ConOpt...)
+	if err != nil {
+		return err
+	}
+	return rows.each(func() error {
+		if err := rows.Scan(dest); err != nil {
+			return err
+		}
+		return fn()
+	})
+}
+
+// 执行查询，返回逐行读取的结果集
+func (Me ormPgsql) queryIter(ctx context.Context, qSql string, qArgs []interface{}) (*URows, error) {
+	qSql, err := UtilFormatExecE(qSql)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	List, err := Me.query(ctx, qSql, qArgs)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	rows, err := utilNewRows(List, Me.scanMode)
+	if err != nil {
+		_ = List.Close()
+		log.Error(err)
+		return nil, err
+	}
+	return rows, nil
+}
+
+// Next 移到下一行，没有数据或出错时返回false并自动关闭结果集，出错信息通过Err获取
+func (r *URows) Next() bool {
+	if r.closed || r.err != nil {
+		return false
+	}
+	if !r.list.Next() {
+		r.err = r.list.Err()
+		_ = r.Close()
+		return false
+	}
+	r.row++
+	return true
+}
+
+// Map 当前行数据转换成map，转换规则同Query(受 WithScanMode 影响)
+func (r *URows) Map() (map[string]interface{}, error) {
+	row, err := utilScanRow(r.list, r.columns, r.types)
+	if err != nil {
+		return nil, &UScanError{Row: r.row, Err: err}
+	}
+	return row, nil
+}
+
+// Scan 当前行数据写入结构体指针dest，写入前清零，字段对应规则同QueryInto
+func (r *URows) Scan(dest interface{}) error {
+	rv := reflect.ValueOf(dest)
+	if dest == nil || rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("dest必须是结构体指针，实际为: %T", dest)
+	}
+	elem := rv.Elem()
+	fields, ok := r.fields[elem.Type()]
+	if !ok {
+		fields = utilStructFields(elem.Type())
+		r.fields[elem.Type()] = fields
+	}
+	elem.Set(reflect.Zero(elem.Type()))
+	if err := utilScanStructRow(r.list, r.columns, fields, elem); err != nil {
+		return &UScanError{Row: r.row, Err: err}
+	}
+	return nil
+}
+
+// Columns 结果集的列名
+func (r *URows) Columns() []string {
+	return r.columns
+}
+
+// Err 遍历过程中的错误，Next返回false后调用
+func (r *URows) Err() error {
+	return r.err
+}
+
+// Close 关闭结果集释放连接，可重复调用
+func (r *URows) Close() error {
+	if r.closed {
+		return r.closeErr
+	}
+	r.closed = true
+	r.closeErr = r.list.Close()
+	return r.closeErr
+}
+
+// 遍历结果集，每行调用一次fn，结束后关闭结果集；fn返回ErrStop时停止并返回nil
+func (r *URows) each(fn func() error) error {
+	defer func() { _ = r.Close() }()
+	for r.Next() {
+		if err := fn(); err != nil {
+			if err == ErrStop {
+				return nil
+			}
+			log.Error(err)
+			return err
+		}
+	}
+	if err := r.Err(); err != nil {
+		log.Error(err)
+		return err
+	}
+	return nil
+}
+
+// 辅助函数: 创建逐行读取的结果集
+func utilNewRows(List *sql.Rows, scanMode string) (*URows, error) {
+	columns, err := List.Columns()
+	if err != nil {
+		return nil, err
+	}
+	rows := &URows{list: List, columns: columns, row: -1, fields: map[reflect.Type]map[string][]int{}}
+	if scanMode == ScanTyped {
+		if rows.types, err = List.ColumnTypes(); err != nil {
+			return nil, err
+		}
+	}
+	return rows, nil
+}
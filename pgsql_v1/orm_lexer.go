@@ -0,0 +1,363 @@
+package pgsql_v1
+
+import (
+	"errors"
+	"strings"
+)
+
+// sql词法单元类型
+const (
+	sqlTokText        = iota // 原样输出的内容：空白、注释、字符串、标识符、运算符等
+	sqlTokPlaceholder        // ? 参数占位符
+	sqlTokQuestion           // ?? 转义的问号，最终输出为 ? 运算符
+	sqlTokNamed              // :name 命名参数
+	sqlTokList               // ::name in列表参数
+	sqlTokPositional         // $n 位置参数，原样输出
+)
+
+// sql词法单元
+type sqlToken struct {
+	kind int    // 类型 sqlTokXxx
+	text string // 原始内容
+	name string // 命名参数和in列表参数的名称
+}
+
+// 占位符可以出现在其后的关键字，其他单词视为表达式结尾(列名、表名等)
+var sqlPlaceholderKeywords = map[string]bool{
+	"select": true, "where": true, "and": true, "or": true, "not": true, "in": true,
+	"like": true, "ilike": true, "similar": true, "to": true, "escape": true, "between": true,
+	"case": true, "when": true, "then": true, "else": true, "limit": true, "offset": true,
+	"values": true, "set": true, "from": true, "by": true, "having": true, "on": true,
+	"returning": true, "return": true, "any": true, "all": true, "some": true, "distinct": true,
+	"first": true, "next": true, "array": true, "interval": true, "as": true, "with": true,
+}
+
+// 辅助函数: sql词法扫描
+// 跳过字符串('...'、E'...')、带引号的标识符("...")、注释(--、/* */)和$$函数体，识别其中的参数：
+//
+//	?      参数占位符；出现在表达式之后(列名、字符串、右括号等)且其后是jsonb运算对象(字符串、?、:name、$n)时视为jsonb的 ? 运算符，
+//	       ?| ?& 为jsonb运算符，?? 为转义的 ? 运算符
+//	$n     位置参数，不能和 ?、:name、::name 混用(见 utilSqlCheckMixed)
+//	:name  命名参数
+//	::name 出现在表达式之后为类型转换(如 id::text)，否则为in列表参数(如 in (::ids))
+func utilSqlTokens(sql string) []sqlToken {
+	var (
+		tokens   = make([]sqlToken, 0)
+		n        = len(sql)
+		i        = 0
+		start    = 0     // 当前原样输出内容的起点
+		prevExpr = false // 上一个有意义的单元是否为表达式结尾
+	)
+
+	// 把 start 到 i 之间的内容作为原样输出单元
+	flush := func() {
+		if i > start {
+			tokens = append(tokens, sqlToken{kind: sqlTokText, text: sql[start:i]})
+		}
+		start = i
+	}
+	// 添加参数单元
+	add := func(kind int, end int, name string) {
+		flush()
+		tokens = append(tokens, sqlToken{kind: kind, text: sql[i:end], name: name})
+		i = end
+		start = i
+	}
+
+	for i < n {
+		c := sql[i]
+		switch {
+		// 空白
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			i++
+
+		// 单行注释
+		case c == '-' && i+1 < n && sql[i+1] == '-':
+			for i < n && sql[i] != '\n' {
+				i++
+			}
+
+		// 多行注释，可嵌套
+		case c == '/' && i+1 < n && sql[i+1] == '*':
+			depth := 0
+			for i < n {
+				if sql[i] == '/' && i+1 < n && sql[i+1] == '*' {
+					depth++
+					i += 2
+				} else if sql[i] == '*' && i+1 < n && sql[i+1] == '/' {
+					depth--
+					i += 2
+					if depth == 0 {
+						break
+					}
+				} else {
+					i++
+				}
+			}
+
+		// 字符串
+		case c == '\'':
+			i = utilSqlSkipQuoted(sql, i, '\'', false)
+			prevExpr = true
+
+		// 带引号的标识符
+		case c == '"':
+			i = utilSqlSkipQuoted(sql, i, '"', false)
+			prevExpr = true
+
+		// $n 位置参数、$tag$ 函数体
+		case c == '$':
+			if i+1 < n && sql[i+1] >= '0' && sql[i+1] <= '9' {
+				j := i + 1
+				for j < n && sql[j] >= '0' && sql[j] <= '9' {
+					j++
+				}
+				add(sqlTokPositional, j, "")
+				prevExpr = true
+			} else if end := utilSqlSkipDollar(sql, i); end > i {
+				i = end
+				prevExpr = true
+			} else {
+				i++
+				prevExpr = false
+			}
+
+		// 单词：关键字、标识符、数字
+		case utilSqlIsWordChar(c):
+			j := i
+			for j < n && (utilSqlIsWordChar(sql[j]) || sql[j] == '$') {
+				j++
+			}
+			// E'...' 转义字符串
+			if j-i == 1 && (c == 'e' || c == 'E') && j < n && sql[j] == '\'' {
+				i = utilSqlSkipQuoted(sql, j, '\'', true)
+				prevExpr = true
+				break
+			}
+			prevExpr = !sqlPlaceholderKeywords[strings.ToLower(sql[i:j])]
+			i = j
+
+		// ? 占位符或jsonb运算符
+		case c == '?':
+			if i+1 < n && (sql[i+1] == '|' || sql[i+1] == '&') {
+				i += 2
+				prevExpr = false
+			} else if i+1 < n && sql[i+1] == '?' {
+				add(sqlTokQuestion, i+2, "")
+				prevExpr = false
+			} else if prevExpr && utilSqlJsonbOperand(sql, i+1) {
+				i++
+				prevExpr = false
+			} else {
+				add(sqlTokPlaceholder, i+1, "")
+				prevExpr = true
+			}
+
+		// :name 命名参数、::name in列表参数、:: 类型转换
+		case c == ':':
+			if i+1 < n && sql[i+1] == ':' {
+				j := i + 2
+				for j < n && utilSqlIsWordChar(sql[j]) {
+					j++
+				}
+				if prevExpr || j == i+2 {
+					// 类型转换，类型名按单词处理
+					i += 2
+					prevExpr = false
+				} else {
+					add(sqlTokList, j, sql[i+2:j])
+					prevExpr = true
+				}
+			} else if i+1 < n && utilSqlIsWordChar(sql[i+1]) && !(sql[i+1] >= '0' && sql[i+1] <= '9') {
+				j := i + 1
+				for j < n && utilSqlIsWordChar(sql[j]) {
+					j++
+				}
+				add(sqlTokNamed, j, sql[i+1:j])
+				prevExpr = true
+			} else {
+				i++
+				prevExpr = false
+			}
+
+		// 右括号
+		case c == ')' || c == ']':
+			i++
+			prevExpr = true
+
+		// 其他运算符
+		default:
+			i++
+			prevExpr = false
+		}
+	}
+	flush()
+
+	return tokens
+}
+
+// 位置i之后(跳过空白)是否为jsonb ? 运算符的右侧运算对象：字符串、? 占位符、:name 命名参数、$n 位置参数
+func utilSqlJsonbOperand(sql string, i int) bool {
+	for i < len(sql) && (sql[i] == ' ' || sql[i] == '\t' || sql[i] == '\n' || sql[i] == '\r' || sql[i] == '\f') {
+		i++
+	}
+	if i >= len(sql) {
+		return false
+	}
+	next := func(k int) byte {
+		if i+k < len(sql) {
+			return sql[i+k]
+		}
+		return 0
+	}
+	switch c := sql[i]; {
+	case c == '\'' || c == '?':
+		return true
+	case c == 'e' || c == 'E':
+		return next(1) == '\''
+	case c == ':':
+		return utilSqlIsWordChar(next(1)) && !(next(1) >= '0' && next(1) <= '9')
+	case c == '$':
+		return (next(1) >= '0' && next(1) <= '9') || utilSqlSkipDollar(sql, i) > i
+	}
+	return false
+}
+
+// 辅助函数: 检查 ?、:name、::name 参数和 $n 位置参数是否混用，混用时转换后的 $n 会重复
+func utilSqlCheckMixed(sql string, tokens []sqlToken) error {
+	params, positional := false, false
+	for _, t := range tokens {
+		switch t.kind {
+		case sqlTokPlaceholder, sqlTokNamed, sqlTokList:
+			params = true
+		case sqlTokPositional:
+			positional = true
+		}
+	}
+	if params && positional {
+		return errors.New("? 或 :name 参数不能和 $n 位置参数混用: " + sql)
+	}
+	return nil
+}
+
+// 只读语句的第一个单词
+var sqlReadOnlyFirst = map[string]bool{"select": true, "with": true, "table": true, "values": true}
+
+// 语句中出现时视为有写操作的单词：写入语句、select into、行锁、序列函数
+var sqlWriteWords = map[string]bool{
+	"insert": true, "update": true, "delete": true, "merge": true, "into": true, "for": true,
+	"nextval": true, "setval": true, "currval": true, "lastval": true,
+}
+
+// 辅助函数: 是否为可以在只读库执行的语句：以 select/with/table/values 开头，字符串、注释、带引号的标识符之外没有写入关键字
+// 说明：无法识别自定义函数中的写操作
+func utilSqlReadOnly(sql string) bool {
+	words := utilSqlWords(sql)
+	if len(words) == 0 || !sqlReadOnlyFirst[words[0]] {
+		return false
+	}
+	for _, w := range words {
+		if sqlWriteWords[w] {
+			return false
+		}
+	}
+	return true
+}
+
+// 辅助函数: 语句中的单词(小写)，跳过字符串、注释、带引号的标识符和$$函数体
+func utilSqlWords(sql string) []string {
+	words := make([]string, 0)
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case c == '-' && i+1 < len(sql) && sql[i+1] == '-':
+			for i < len(sql) && sql[i] != '\n' {
+				i++
+			}
+		case c == '/' && i+1 < len(sql) && sql[i+1] == '*':
+			depth := 0
+			for i < len(sql) {
+				if sql[i] == '/' && i+1 < len(sql) && sql[i+1] == '*' {
+					depth++
+					i += 2
+				} else if sql[i] == '*' && i+1 < len(sql) && sql[i+1] == '/' {
+					depth--
+					i += 2
+					if depth == 0 {
+						break
+					}
+				} else {
+					i++
+				}
+			}
+		case c == '\'':
+			i = utilSqlSkipQuoted(sql, i, '\'', false)
+		case c == '"':
+			i = utilSqlSkipQuoted(sql, i, '"', false)
+		case c == '$':
+			if end := utilSqlSkipDollar(sql, i); end > i {
+				i = end
+			} else {
+				i++
+			}
+		case utilSqlIsWordChar(c):
+			j := i
+			for j < len(sql) && (utilSqlIsWordChar(sql[j]) || sql[j] == '$') {
+				j++
+			}
+			if j-i == 1 && (c == 'e' || c == 'E') && j < len(sql) && sql[j] == '\'' {
+				i = utilSqlSkipQuoted(sql, j, '\'', true)
+				break
+			}
+			words = append(words, strings.ToLower(sql[i:j]))
+			i = j
+		default:
+			i++
+		}
+	}
+	return words
+}
+
+// 跳过引号包裹的内容，两个连续引号为转义；backslash为true时反斜杠也作为转义符；返回结束引号之后的位置
+func utilSqlSkipQuoted(sql string, i int, quote byte, backslash bool) int {
+	i++
+	for i < len(sql) {
+		switch {
+		case backslash && sql[i] == '\\':
+			i += 2
+		case sql[i] == quote && i+1 < len(sql) && sql[i+1] == quote:
+			i += 2
+		case sql[i] == quote:
+			return i + 1
+		default:
+			i++
+		}
+	}
+	return len(sql)
+}
+
+// 跳过 $tag$...$tag$ 函数体，不是函数体起点时返回i
+func utilSqlSkipDollar(sql string, i int) int {
+	j := i + 1
+	for j < len(sql) && sql[j] != '$' {
+		if !utilSqlIsWordChar(sql[j]) || (j == i+1 && sql[j] >= '0' && sql[j] <= '9') {
+			return i
+		}
+		j++
+	}
+	if j >= len(sql) {
+		return i
+	}
+	tag := sql[i : j+1]
+	end := strings.Index(sql[j+1:], tag)
+	if end < 0 {
+		return len(sql)
+	}
+	return j + 1 + end + len(tag)
+}
+
+// 是否为单词字符：字母、数字、下划线和非ascii字符
+func utilSqlIsWordChar(c byte) bool {
+	return c == '_' || c >= 0x80 ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
@@ -0,0 +1,137 @@
+package pgsql_v1
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUtilFormatExec(t *testing.T) {
+	cases := []struct {
+		sql  string
+		want string
+	}{
+		{"select * from t where a = ? and b = ?", "select * from t where a = $1 and b = $2"},
+		{"insert into t(a,b) values (?,?)", "insert into t(a,b) values ($1,$2)"},
+		{"select '?', \"a?b\", E'it\\'s ?', ? -- ?\n", "select '?', \"a?b\", E'it\\'s ?', $1 -- ?\n"},
+		{"select ? /* ? /* ? */ */ from t", "select $1 /* ? /* ? */ */ from t"},
+		{"select $$ ? $$, $body$ ? $body$, ?", "select $$ ? $$, $body$ ? $body$, $1"},
+		{"select * from t where tags ? 'vip' and id = ?", "select * from t where tags ? 'vip' and id = $1"},
+		{"select * from t where (a->'b') ? ? and id = ?", "select * from t where (a->'b') ? $1 and id = $2"},
+		{"select * from t where tags ?| array['a'] and tags ?& ?", "select * from t where tags ?| array['a'] and tags ?& $1"},
+		{"select * from t where tags ?? x", "select * from t where tags ? x"},
+		{"select ts at time zone ? from t", "select ts at time zone $1 from t"},
+		{"select * from t where flag is ? and id = ?", "select * from t where flag is $1 and id = $2"},
+		{"select * from t where id between ? and ? limit ? offset ?", "select * from t where id between $1 and $2 limit $3 offset $4"},
+		{"select id::text from t where id = ?", "select id::text from t where id = $1"},
+	}
+	for _, c := range cases {
+		if got := UtilFormatExec(c.sql); got != c.want {
+			t.Errorf("UtilFormatExec(%q) = %q, want %q", c.sql, got, c.want)
+		}
+	}
+}
+
+func TestUtilSqlTokens(t *testing.T) {
+	cases := []struct {
+		sql   string
+		kinds []int
+		names []string
+	}{
+		{"a = :name", []int{sqlTokText, sqlTokNamed}, []string{"", "name"}},
+		{"id in (::ids)", []int{sqlTokText, sqlTokList, sqlTokText}, []string{"", "ids", ""}},
+		{"id::text = :v::varchar", []int{sqlTokText, sqlTokNamed, sqlTokText}, []string{"", "v", ""}},
+		{"'10:30' = ':x'", []int{sqlTokText}, []string{""}},
+		{"a = $1 and b = ?", []int{sqlTokText, sqlTokPositional, sqlTokText, sqlTokPlaceholder}, []string{"", "", "", ""}},
+		{"tags ? :tag", []int{sqlTokText, sqlTokNamed}, []string{"", "tag"}},
+	}
+	for _, c := range cases {
+		kinds, names := make([]int, 0), make([]string, 0)
+		for _, tok := range utilSqlTokens(c.sql) {
+			kinds = append(kinds, tok.kind)
+			names = append(names, tok.name)
+		}
+		if !reflect.DeepEqual(kinds, c.kinds) || !reflect.DeepEqual(names, c.names) {
+			t.Errorf("utilSqlTokens(%q) = %v %v, want %v %v", c.sql, kinds, names, c.kinds, c.names)
+		}
+	}
+}
+
+func TestUtilMakeCondition(t *testing.T) {
+	cases := []struct {
+		sql     string
+		cond    map[string]interface{}
+		want    string
+		args    []interface{}
+		wantErr bool
+	}{
+		{"id in (::ids) and name = :name", map[string]interface{}{"ids": []int{1, 2}, "name": "n"}, "id in (?,?) and name = ?", []interface{}{1, 2, "n"}, false},
+		{"name = :name", map[string]interface{}{}, "", nil, true},
+		{"id in (::ids)", map[string]interface{}{"ids": []int{}}, "", nil, true},
+		{"id in (::ids)", map[string]interface{}{"ids": 1}, "", nil, true},
+		{"a = $1 and b = :b", map[string]interface{}{"b": 1}, "", nil, true},
+		{"a = $1 and b = ?", map[string]interface{}{}, "", nil, true},
+		{"a = $1 and b = $2", map[string]interface{}{}, "a = $1 and b = $2", []interface{}{}, false},
+	}
+	for _, c := range cases {
+		got, args, err := utilMakeCondition(c.sql, c.cond)
+		if (err != nil) != c.wantErr {
+			t.Errorf("utilMakeCondition(%q) err = %v, wantErr %v", c.sql, err, c.wantErr)
+			continue
+		}
+		if err == nil && (got != c.want || !reflect.DeepEqual(args, c.args)) {
+			t.Errorf("utilMakeCondition(%q) = %q %v, want %q %v", c.sql, got, args, c.want, c.args)
+		}
+	}
+}
+
+func TestUtilSqlReadOnly(t *testing.T) {
+	cases := []struct {
+		sql  string
+		want bool
+	}{
+		{"select * from demo where id = ?", true},
+		{"  -- 注释\n/* x */ SELECT 'insert', \"update\" from demo", true},
+		{"with t as (select 1) select * from t", true},
+		{"values (1), (2)", true},
+		{"select $$delete$$, E'it\\'s update'", true},
+		{"update demo set a = 1 returning *", false},
+		{"insert into demo(a) values (1) returning id", false},
+		{"with d as (delete from demo returning *) select * from d", false},
+		{"select nextval('demo_id_seq')", false},
+		{"select * into demo2 from demo", false},
+		{"select * from demo for update", false},
+		{"call p()", false},
+		{"", false},
+	}
+	for _, c := range cases {
+		if got := utilSqlReadOnly(c.sql); got != c.want {
+			t.Errorf("utilSqlReadOnly(%q) = %v, want %v", c.sql, got, c.want)
+		}
+	}
+}
+
+func TestUtilFormatExecMixed(t *testing.T) {
+	for _, sql := range []string{
+		"select * from t where a = $1 and b = ?",
+		"update t set a = ? where id = $1",
+		"select * from t where a = :a and b = $2",
+	} {
+		if got, err := UtilFormatExecE(sql); err == nil {
+			t.Errorf("UtilFormatExecE(%q) = %q, want error", sql, got)
+		}
+		if got := UtilFormatExec(sql); got != "" {
+			t.Errorf("UtilFormatExec(%q) = %q, want empty string", sql, got)
+		}
+	}
+
+	// 只有$n，或者$n出现在字符串、注释中时不算混用
+	for sql, want := range map[string]string{
+		"select * from t where a = $1 and b = $2":    "select * from t where a = $1 and b = $2",
+		"select '$1' from t where a = ? -- $2":       "select '$1' from t where a = $1 -- $2",
+		"select $body$ $1 $body$ from t where a = ?": "select $body$ $1 $body$ from t where a = $1",
+	} {
+		if got, err := UtilFormatExecE(sql); err != nil || got != want {
+			t.Errorf("UtilFormatExecE(%q) = %q, %v, want %q", sql, got, err, want)
+		}
+	}
+}
@@ -0,0 +1,305 @@
+package pgsql_v1
+
+import (
+	"context"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"sync"
+)
+
+// UParallel 结构体13：并行读取参数
+type UParallel struct {
+	Workers int  // 并发数，默认4，不超过句柄的最大连接数(maxConn)
+	Chunks  int  // 分块数，默认按 pg_class.reltuples 估算，每块约 RowLimit*10 行，最少为Workers，最多为Workers*16
+	Ordered bool // 是否按全局顺序回调：为true时回调在同一个goroutine中按顺序执行；为false时回调在多个goroutine中并发执行
+}
+
+// QueryAllParallel 特殊7：按第一个排序字段把数据表分成多块，并发读取全表数据，参数同QueryAllCircle
+// 示例:
+//
+//	var num int64
+//	err := QueryAllParallel(pgsql_v1.UFastQuery{
+//		Table:    "demo",
+//		Fields:   "*",
+//		PriField: "id",
+//		RowLimit: 2000,
+//	}, pgsql_v1.UParallel{Workers: 8}, func(V map[string]interface{}) bool {
+//		atomic.AddInt64(&num, 1) // Ordered为false时回调会并发执行，必须是并发安全的
+//		return true              // true:继续 false：终止全部读取
+//	})
+//
+// 说明：第一个排序字段为整数时按 min/max 均分，否则按 pg_stats 的直方图分块(需要先 analyze)，无法分块时退化为单块读取；
+// 每块内按 QueryAllCircle 的方式分批读取；不支持设置起点和断点；在事务中调用时不并行，按顺序读取
+func (Me ormPgsql) QueryAllParallel(Cfg UFastQuery, opt UParallel, backFunc func(V map[string]interface{}) bool) error {
+	return Me.QueryAllParallelCtx(context.Background(), Cfg, opt, backFunc)
+}
+
+// QueryAllParallelCtx 特殊7：并发读取全表数据，可通过ctx取消或设置超时
+func (Me ormPgsql) QueryAllParallelCtx(ctx context.Context, Cfg UFastQuery, opt UParallel, backFunc func(V map[string]interface{}) bool) error {
+	if Me.initErr {
+		log.Error("数据库未连接成功", Me.dbCfgName, Me.dbName)
+		return errors.New("数据库未连接成功:" + Me.dbCfgName + " . " + Me.dbName)
+	}
+
+	// 1、生成分批读取的sql，检查排序字段
+	circle, err := utilCircleSql(Cfg)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	if circle.beginVals != nil || Cfg.Checkpoint != nil {
+		err := errors.New("并行读取不支持设置起点和断点")
+		log.Error(err)
+		return err
+	}
+	if err := Me.checkUniqueOrderCtx(ctx, Cfg.Table, circle.orders); err != nil {
+		log.Error(err)
+		return err
+	}
+
+	// 2、事务中只有一个连接，按顺序读取
+	if Me.tx != nil {
+		return Me.circleScanCtx(ctx, circle, nil, false, nil, utilCircleRows(backFunc))
+	}
+
+	// 3、并发数和分块
+	workers := opt.Workers
+	if workers <= 0 {
+		workers = 4
+	}
+	if max := Me.pool.get().Stats().MaxOpenConnections; max > 0 && workers > max {
+		workers = max
+	}
+	chunks := opt.Chunks
+	if chunks <= 0 {
+		if chunks, err = Me.parallelChunksCtx(ctx, Cfg.Table, circle.limit, workers); err != nil {
+			log.Error(err)
+			return err
+		}
+	}
+	bounds, err := Me.parallelBoundsCtx(ctx, Cfg.Table, circle, chunks)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+
+	// 4、每块的范围，倒序读取时从最后一块开始
+	parts := make([]circleSql, 0, len(bounds)+1)
+	for i := 0; i <= len(bounds); i++ {
+		var lo, hi interface{}
+		if i > 0 {
+			lo = bounds[i-1]
+		}
+		if i < len(bounds) {
+			hi = bounds[i]
+		}
+		parts = append(parts, circle.withRange(lo, hi))
+	}
+	if circle.compare == "<" {
+		for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+			parts[i], parts[j] = parts[j], parts[i]
+		}
+	}
+
+	// 5、并发读取
+	return Me.parallelScanCtx(ctx, parts, workers, opt.Ordered, backFunc)
+}
+
+// 并发读取各块：workers个goroutine按顺序领取分块；ordered为true时各块数据通过通道按块顺序回调
+func (Me ormPgsql) parallelScanCtx(pctx context.Context, parts []circleSql, workers int, ordered bool, backFunc func(V map[string]interface{}) bool) error {
+	ctx, cancel := context.WithCancel(pctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		stopped  bool // 回调返回false
+		jobs     = make(chan int)
+		chans    = make([]chan map[string]interface{}, len(parts))
+	)
+
+	// 停止全部读取，记录第一个错误
+	stop := func(err error) {
+		mu.Lock()
+		if err == nil {
+			stopped = true
+		} else if firstErr == nil && !stopped {
+			firstErr = err
+		}
+		mu.Unlock()
+		cancel()
+	}
+
+	// 1、回调：无序时直接回调，有序时写入该块的通道
+	emit := func(k int) func(rows []map[string]interface{}) error {
+		if !ordered {
+			return func(rows []map[string]interface{}) error {
+				for _, V := range rows {
+					if !backFunc(V) {
+						stop(nil)
+						return ErrStop
+					}
+				}
+				return nil
+			}
+		}
+		return func(rows []map[string]interface{}) error {
+			for _, V := range rows {
+				select {
+				case chans[k] <- V:
+				case <-ctx.Done():
+					return ErrStop
+				}
+			}
+			return nil
+		}
+	}
+	if ordered {
+		for k := range chans {
+			chans[k] = make(chan map[string]interface{}, parts[k].limit)
+		}
+	}
+
+	// 2、读取各块
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := range jobs {
+				if err := Me.reader(false).circleScanCtx(ctx, parts[k], nil, false, nil, emit(k)); err != nil {
+					stop(err)
+				}
+				if ordered {
+					close(chans[k])
+				}
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for k := range parts {
+			select {
+			case jobs <- k:
+			case <-ctx.Done():
+				// 未领取的块直接结束
+				if ordered {
+					for ; k < len(parts); k++ {
+						close(chans[k])
+					}
+				}
+				return
+			}
+		}
+	}()
+
+	// 3、有序时按块顺序回调
+	if ordered {
+	loop:
+		for k := range chans {
+			for V := range chans[k] {
+				if ctx.Err() != nil {
+					break loop
+				}
+				if !backFunc(V) {
+					stop(nil)
+					break loop
+				}
+			}
+		}
+		cancel()
+	}
+	wg.Wait()
+
+	// 4、外部ctx取消时返回ctx的错误，回调终止时返回nil
+	mu.Lock()
+	defer mu.Unlock()
+	if stopped {
+		return nil
+	}
+	if firstErr == nil {
+		firstErr = pctx.Err()
+	}
+	if firstErr != nil {
+		log.Error(firstErr)
+	}
+	return firstErr
+}
+
+// 按 pg_class.reltuples 估算分块数，每块约 rowLimit*10 行，范围为 workers 到 workers*16
+func (Me ormPgsql) parallelChunksCtx(ctx context.Context, table string, rowLimit int, workers int) (int, error) {
+	num, err := Me.estimateRowsCtx(ctx, table)
+	if err != nil {
+		return 0, err
+	}
+	chunks := workers
+	if num > 0 {
+		chunks = int(num / int64(rowLimit*10))
+	}
+	if chunks < workers {
+		chunks = workers
+	}
+	if chunks > workers*16 {
+		chunks = workers * 16
+	}
+	return chunks, nil
+}
+
+// 获取分块的边界值(升序，共chunks-1个)：整数字段按 min/max 均分，否则按 pg_stats 直方图；无法分块时返回nil
+func (Me ormPgsql) parallelBoundsCtx(ctx context.Context, table string, c circleSql, chunks int) ([]interface{}, error) {
+	if chunks <= 1 {
+		return nil, nil
+	}
+
+	// 1、整数字段按 min/max 均分
+	res, err := Me.WithScanMode(ScanTyped).queryMaps(ctx, "select min("+c.first+") as lo, max("+c.first+") as hi"+c.from, c.args)
+	if err != nil {
+		return nil, err
+	}
+	if len(res) == 0 || res[0]["lo"] == nil {
+		return nil, nil
+	}
+	if lo, ok := res[0]["lo"].(int64); ok {
+		hi := res[0]["hi"].(int64)
+		// 用无符号数计算跨度，避免 hi-lo 超出int64范围；lo+step*i 在 lo 和 hi 之间，按补码回绕结果正确
+		step := (uint64(hi) - uint64(lo)) / uint64(chunks)
+		if step == 0 {
+			return nil, nil
+		}
+		bounds := make([]interface{}, 0, chunks-1)
+		for i := uint64(1); i < uint64(chunks); i++ {
+			bounds = append(bounds, lo+int64(step*i))
+		}
+		return bounds, nil
+	}
+
+	// 2、其他类型按 pg_stats 直方图
+	quoted, err := UtilQuoteTable(table)
+	if err != nil {
+		return nil, err
+	}
+	res, err = Me.WithScanMode(ScanString).QueryCtx(ctx, `SELECT b.val
+FROM pg_stats AS s
+JOIN pg_class AS c ON c.relname = s.tablename
+JOIN pg_namespace AS n ON n.oid = c.relnamespace AND n.nspname = s.schemaname
+JOIN LATERAL unnest(s.histogram_bounds::text::text[]) WITH ORDINALITY AS b(val, ord) ON true
+WHERE c.oid = :table::regclass AND s.attname = :field
+ORDER BY b.ord`, map[string]interface{}{"table": quoted, "field": c.orders[0]})
+	if err != nil {
+		return nil, err
+	}
+	if len(res) <= 2 {
+		return nil, nil
+	}
+	if chunks > len(res)-1 {
+		chunks = len(res) - 1
+	}
+	bounds := make([]interface{}, 0, chunks-1)
+	for i := 1; i < chunks; i++ {
+		v := res[i*(len(res)-1)/chunks]["val"]
+		if len(bounds) == 0 || bounds[len(bounds)-1] != v {
+			bounds = append(bounds, v)
+		}
+	}
+	return bounds, nil
+}
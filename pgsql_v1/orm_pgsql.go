@@ -1,12 +1,16 @@
 package pgsql_v1
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"errors"
 	"fmt"
+	"github.com/lib/pq"
 	log "github.com/sirupsen/logrus"
+	"net"
 	"reflect"
-	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -14,13 +18,34 @@ import (
 
 // 结构体1：pgsql操作结构
 type ormPgsql struct {
-	o          *sql.DB // 数据库句柄
-	dbInstance string  // 名称:"dbInstance:||" + dbCfgName + ":" + dbName
-	dbCfgName  string  // 名称:default等
-	dbName     string  // 数据库名称
-	initErr    bool    // 初始化成功标记 0:未成功，1:成功
+	pool       *dbPool  // 连接池，重新加载配置时替换其中的数据库句柄
+	tx         *txState // 事务状态，非nil时所有操作都在该事务内执行
+	dbInstance string   // 名称:"dbInstance:||" + dbCfgName + ":" + dbName
+	dbCfgName  string   // 名称:default等
+	dbName     string   // 数据库名称
+	scanMode   string   // 查询结果转换模式：ScanString/ScanTyped
+	expectRows *int64   // 修改和删除期望影响的行数，nil为不检查
+	primary    bool     // 读取也使用主库
+	readIdx    int      // 固定使用的只读库序号+1，0为不使用只读库；分批读取时同一次读取的各批使用同一个库
+	initErr    bool     // 初始化成功标记 0:未成功，1:成功
 }
 
+// URowsAffectedError 结构体7：修改和删除影响的行数和期望的不一致
+type URowsAffectedError struct {
+	Expected int64 // 期望的行数
+	Actual   int64 // 实际影响的行数
+}
+
+func (e *URowsAffectedError) Error() string {
+	return "影响的行数不一致，期望" + strconv.FormatInt(e.Expected, 10) + "行，实际" + strconv.FormatInt(e.Actual, 10) + "行"
+}
+
+// 查询结果转换模式
+const (
+	ScanString = "string" // 所有列转换成字符串，默认值，兼容旧版本
+	ScanTyped  = "typed"  // 按列类型返回 int64/float64/bool/time.Time/[]byte/string
+)
+
 // UTbDesc 结构体2：字段信息结构体
 type UTbDesc struct {
 	Field  string // 字段名
@@ -31,36 +56,71 @@ type UTbDesc struct {
 
 // UFastQuery 结构体3：批量快速读取配置参数
 type UFastQuery struct {
-	Table          string      // 表名
-	Fields         string      // 检索的字段
-	PriField       string      // 主键字段名
-	PriSort        string      // 顺序 asc/desc
-	RowLimit       int         // 单词取出行数
-	BeginVal       interface{} // 起点(检索时不包括这条)
-	BeginValIgnore bool        // 是否包含起点
+	Table          string                 // 表名
+	Fields         string                 // 检索的字段
+	PriField       string                 // 主键字段名，OrderFields为空时按该字段排序
+	OrderFields    []string               // 排序字段，可以是多个，如 tenant_id,id 或 updated,id；必须包含主键或某个唯一索引的全部字段，且不能为null
+	PriSort        string                 // 顺序 asc/desc，对全部排序字段生效
+	Where          map[string]interface{} // 过滤条件，格式同QueryTable的条件参数
+	RowLimit       int                    // 单词取出行数
+	BeginVal       interface{}            // 起点(检索时不包括这条)
+	BeginVals      []interface{}          // 多个排序字段的起点，和OrderFields一一对应，设置后BeginVal不生效
+	BeginValIgnore bool                   // 是否包含起点
+	Checkpoint     UCheckpoint            // 断点，每批数据回调完成后保存该批最后一行的排序字段值
+	Resume         bool                   // 是否从Checkpoint中保存的断点继续读取(不包含断点)，没有断点时按起点读取
+	Progress       func(p UProgress)      // 进度，每批数据回调完成后调用
+}
+
+// UProgress 结构体17：QueryAllCircle 的读取进度
+type UProgress struct {
+	Rows    int64 // 已回调的行数
+	Batches int64 // 已回调的批数
+	Total   int64 // 预计总行数(pg_class.reltuples，不考虑过滤条件)，未统计过时为-1
 }
 
 // Insert 数据操作1： 写入数据
 // 示例: err := Insert("user" , map[string]interface{}{ "user_id":123,"user_name":"张三"} )
 func (Me ormPgsql) Insert(table string, row map[string]interface{}, AutoIncreaseField ...string) (int64, error) {
+	return Me.InsertCtx(context.Background(), table, row, AutoIncreaseField...)
+}
+
+// InsertCtx 数据操作1： 写入数据，可通过ctx取消或设置超时
+func (Me ormPgsql) InsertCtx(ctx context.Context, table string, row map[string]interface{}, AutoIncreaseField ...string) (int64, error) {
 	if Me.initErr {
 		log.Error("数据库未连接成功", Me.dbCfgName, Me.dbName)
 		return 0, errors.New("数据库未连接成功:" + Me.dbCfgName + " . " + Me.dbName)
 	}
 
 	// -1- 拼凑sql和value
-	KeySql, KeyValues := Me.UtilInsert(table, row)
+	KeySql, KeyValues, err := Me.UtilInsertE(table, row)
+	if err != nil {
+		log.Error(err)
+		return 0, err
+	}
 
 	// -2- 写入后数据的自增Id：写入数据后数据库生成的
 	KeyId := int64(0)
 
 	// -3- 执行写入操作
+	returning := ""
 	if len(AutoIncreaseField) > 0 && AutoIncreaseField[0] != "" {
-		if err := Me.o.QueryRow(UtilFormatExec(KeySql+" returning "+AutoIncreaseField[0]), KeyValues...).Scan(&KeyId); err != nil {
+		field, err := UtilQuoteIdent(AutoIncreaseField[0])
+		if err != nil {
+			log.Error(err)
+			return 0, err
+		}
+		returning = " returning " + field
+	}
+	if KeySql, err = UtilFormatExecE(KeySql + returning); err != nil {
+		log.Error(err)
+		return 0, err
+	}
+	if returning != "" {
+		if err := Me.db().QueryRowContext(ctx, KeySql, KeyValues...).Scan(&KeyId); err != nil {
 			log.Error(err)
 			return 0, err
 		}
-	} else if _, err := Me.o.Exec(UtilFormatExec(KeySql), KeyValues...); err != nil {
+	} else if _, err := Me.db().ExecContext(ctx, KeySql, KeyValues...); err != nil {
 		log.Error(err)
 		return 0, err
 	}
@@ -68,101 +128,479 @@ func (Me ormPgsql) Insert(table string, row map[string]interface{}, AutoIncrease
 	return KeyId, nil
 }
 
-// InsertManyTransaction 数据操作2： 批量写入数据
-// 示例: err := InsertManyTransaction("user" , []map[string]interface{}{ {"user_id":123,"user_name":"张三"} } )
-func (Me ormPgsql) InsertManyTransaction(table string, rows []map[string]interface{}) error {
+// InsertReturning 数据操作1： 写入数据，返回写入后数据的指定字段，"*"为全部字段，不传时默认为"*"
+// 示例: row, err := InsertReturning("user" , map[string]interface{}{ "user_name":"张三"} , "id", "created" )
+//
+// 说明：可获取uuid、text等非整数主键和数据库生成的默认值
+func (Me ormPgsql) InsertReturning(table string, row map[string]interface{}, fields ...string) (map[string]interface{}, error) {
+	return Me.InsertReturningCtx(context.Background(), table, row, fields...)
+}
+
+// InsertReturningCtx 数据操作1： 写入数据，返回写入后数据的指定字段，可通过ctx取消或设置超时
+func (Me ormPgsql) InsertReturningCtx(ctx context.Context, table string, row map[string]interface{}, fields ...string) (map[string]interface{}, error) {
 	if Me.initErr {
 		log.Error("数据库未连接成功", Me.dbCfgName, Me.dbName)
-		return errors.New("数据库未连接成功:" + Me.dbCfgName + " . " + Me.dbName)
+		return nil, errors.New("数据库未连接成功:" + Me.dbCfgName + " . " + Me.dbName)
 	}
 
-	if len(rows) <= 0 {
-		return nil
+	// 1、拼凑sql和value
+	KeySql, KeyValues, err := Me.utilInsertReturning(table, row, fields)
+	if err != nil {
+		log.Error(err)
+		return nil, err
 	}
 
-	var (
-		fields []string
-		Sql    string
-		err    error
-	)
+	// 2、执行写入操作，读取返回的数据
+	if KeySql, err = UtilFormatExecE(KeySql); err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	List, err := Me.db().QueryContext(ctx, KeySql, KeyValues...)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	rows, err := utilScan(List, Me.scanMode)
+	_ = List.Close()
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	return rows[0], nil
+}
+
+// InsertReturningInto 数据操作1： 写入数据，写入后数据的指定字段写入结构体dest，不传fields时默认为"*"
+// 示例: err := InsertReturningInto(&user, "user" , map[string]interface{}{ "user_name":"张三"} )
+func (Me ormPgsql) InsertReturningInto(dest interface{}, table string, row map[string]interface{}, fields ...string) error {
+	return Me.InsertReturningIntoCtx(context.Background(), dest, table, row, fields...)
+}
+
+// InsertReturningIntoCtx 数据操作1： 写入数据，写入后数据的指定字段写入结构体dest，可通过ctx取消或设置超时
+func (Me ormPgsql) InsertReturningIntoCtx(ctx context.Context, dest interface{}, table string, row map[string]interface{}, fields ...string) error {
+	if Me.initErr {
+		log.Error("数据库未连接成功", Me.dbCfgName, Me.dbName)
+		return errors.New("数据库未连接成功:" + Me.dbCfgName + " . " + Me.dbName)
+	}
 
-	txO, err := Me.o.Begin()
+	KeySql, KeyValues, err := Me.utilInsertReturning(table, row, fields)
 	if err != nil {
+		log.Error(err)
 		return err
 	}
+	return Me.queryInto(ctx, dest, KeySql, KeyValues)
+}
 
-	for _, row := range rows {
-		var values []interface{}
-		// 拼凑sql
-		if Sql == "" {
-			var fs, vkeys []string
-			for k, v := range row {
-				fs = append(fs, ""+k+"")
-				fields = append(fields, k)
-				vkeys = append(vkeys, "?")
-				values = append(values, v)
-			}
-			Sql = "insert into " + table + "(" + strings.Join(fs, ",") + ")" + "values (" + strings.Join(vkeys, ",") + ")"
+// 获取 insert ... returning 的sql和参数
+func (Me ormPgsql) utilInsertReturning(table string, row map[string]interface{}, fields []string) (string, []interface{}, error) {
+	if len(fields) == 0 {
+		fields = []string{"*"}
+	}
+	KeySql, KeyValues, err := Me.UtilInsertE(table, row)
+	if err != nil {
+		return "", nil, err
+	}
+	returning, err := utilReturningSql(fields)
+	if err != nil {
+		return "", nil, err
+	}
+	return KeySql + returning, KeyValues, nil
+}
+
+// InsertManyTransaction 数据操作2： 批量写入数据
+// 示例: err := InsertManyTransaction("user" , []map[string]interface{}{ {"user_id":123,"user_name":"张三"} } )
+func (Me ormPgsql) InsertManyTransaction(table string, rows []map[string]interface{}) error {
+	return Me.InsertManyTransactionCtx(context.Background(), table, rows)
+}
+
+// InsertManyTransactionCtx 数据操作2： 批量写入数据，可通过ctx取消或设置超时
+func (Me ormPgsql) InsertManyTransactionCtx(ctx context.Context, table string, rows []map[string]interface{}) error {
+	_, err := Me.InsertManyCtx(ctx, table, rows)
+	return err
+}
+
+// InsertMany 数据操作2： 批量写入数据，可返回写入后数据的自增Id
+// 示例: ids, err := InsertMany("user" , []map[string]interface{}{ {"user_id":123,"user_name":"张三"} }, "id" )
+//
+// 说明：每行的字段必须一致；使用多行 insert ... values (...),(...) 分批写入，每批参数不超过65535个，
+// 全部批次在同一事务中执行(已处于事务中时使用保存点)；传入AutoIncreaseField时按写入顺序返回自增Id，ids[i]对应rows[i]
+func (Me ormPgsql) InsertMany(table string, rows []map[string]interface{}, AutoIncreaseField ...string) ([]int64, error) {
+	return Me.InsertManyCtx(context.Background(), table, rows, AutoIncreaseField...)
+}
+
+// InsertManyCtx 数据操作2： 批量写入数据，可通过ctx取消或设置超时
+func (Me ormPgsql) InsertManyCtx(ctx context.Context, table string, rows []map[string]interface{}, AutoIncreaseField ...string) ([]int64, error) {
+	if Me.initErr {
+		log.Error("数据库未连接成功", Me.dbCfgName, Me.dbName)
+		return nil, errors.New("数据库未连接成功:" + Me.dbCfgName + " . " + Me.dbName)
+	}
 
-		} else {
-			for _, k := range fields {
-				values = append(values, row[k])
+	if len(rows) <= 0 {
+		return nil, nil
+	}
+
+	// 1、returning
+	KeyReturning := ""
+	if len(AutoIncreaseField) > 0 && AutoIncreaseField[0] != "" {
+		field, err := UtilQuoteIdent(AutoIncreaseField[0])
+		if err != nil {
+			log.Error(err)
+			return nil, err
+		}
+		KeyReturning = " returning " + field
+	}
+
+	// 2、分批写入，读取自增Id
+	KeyIds := make([]int64, 0)
+	var scan func(List *sql.Rows) error
+	if KeyReturning != "" {
+		scan = func(List *sql.Rows) error {
+			for List.Next() {
+				id := int64(0)
+				if err := List.Scan(&id); err != nil {
+					return err
+				}
+				KeyIds = append(KeyIds, id)
 			}
+			return List.Err()
 		}
-		// 执行sql
-		_, err := txO.Exec(UtilFormatExec(Sql), values...)
-		if err != nil {
+	}
+	if err := Me.insertBatchesCtx(ctx, table, rows, KeyReturning, nil, scan != nil, scan); err != nil {
+		return nil, err
+	}
+
+	return KeyIds, nil
+}
+
+// 分批写入：每批执行 insert into table(字段) values (...),(...) + suffix，suffixArgs为suffix中?对应的参数
+// 每行的字段必须一致；每批参数不超过65535个；全部批次在同一事务中执行；scan不为nil时用于读取每批返回的数据
+// ordered为true时每行附加序号，按序号 insert ... select，returning 返回的顺序和 rows 一致
+func (Me ormPgsql) insertBatchesCtx(ctx context.Context, table string, rows []map[string]interface{}, suffix string, suffixArgs []interface{}, ordered bool, scan func(List *sql.Rows) error) error {
+	// 1、字段：使用第一行的字段，检查每行字段一致
+	fields := utilSortedKeys(rows[0])
+	if len(fields) == 0 {
+		return errors.New("写入数据不能为空")
+	}
+	for i, row := range rows {
+		if len(row) != len(fields) {
+			err := fmt.Errorf("第%d行的字段和第一行不一致", i)
 			log.Error(err)
-			_ = txO.Rollback()
 			return err
 		}
+		for _, k := range fields {
+			if _, ok := row[k]; !ok {
+				err := fmt.Errorf("第%d行缺少字段: %s", i, k)
+				log.Error(err)
+				return err
+			}
+		}
 	}
-	err = txO.Commit()
+
+	// 2、表名、字段名
+	KeyTable, err := UtilQuoteTable(table)
 	if err != nil {
 		log.Error(err)
-		_ = txO.Rollback()
 		return err
 	}
+	quotedFields := make([]string, len(fields))
+	for i, k := range fields {
+		if quotedFields[i], err = UtilQuoteIdent(k); err != nil {
+			log.Error(err)
+			return err
+		}
+	}
 
-	return nil
+	// 2.1、按序号写入时，values中的参数没有类型，需要按字段类型转换
+	var KeyCasts []string
+	if ordered {
+		types, err := Me.columnTypesCtx(ctx, KeyTable)
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+		KeyCasts = make([]string, len(fields))
+		for i, k := range fields {
+			KeyCasts[i] = types[k]
+		}
+	}
+
+	// 3、每批行数：参数个数不超过65535
+	batchSize := utilInsertBatchSize(len(fields), len(suffixArgs))
+	if batchSize <= 0 {
+		return errors.New("参数个数超过65535")
+	}
+
+	// 4、在事务中分批写入
+	return Me.TransactionCtx(ctx, func(tx *Tx) error {
+		for begin := 0; begin < len(rows); begin += batchSize {
+			end := begin + batchSize
+			if end > len(rows) {
+				end = len(rows)
+			}
+
+			// 4.1、拼凑一批的sql和参数
+			values := make([]interface{}, 0, (end-begin)*len(fields)+len(suffixArgs))
+			for _, row := range rows[begin:end] {
+				for _, k := range fields {
+					values = append(values, row[k])
+				}
+			}
+			values = append(values, suffixArgs...)
+			Sql, err := UtilFormatExecE(utilInsertBatchSql(KeyTable, quotedFields, KeyCasts, end-begin) + suffix)
+			if err != nil {
+				log.Error(err)
+				return err
+			}
+
+			// 4.2、执行sql
+			if scan == nil {
+				if _, err := tx.db().ExecContext(ctx, Sql, values...); err != nil {
+					log.Error(err)
+					return err
+				}
+				continue
+			}
+			List, err := tx.db().QueryContext(ctx, Sql, values...)
+			if err != nil {
+				log.Error(err)
+				return err
+			}
+			err = scan(List)
+			_ = List.Close()
+			if err != nil {
+				log.Error(err)
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// 读取数据表每个字段的类型(不含长度、精度)，用于转换 values 中参数的类型；长度、精度仍由写入时检查
+func (Me ormPgsql) columnTypesCtx(ctx context.Context, KeyTable string) (map[string]string, error) {
+	sql := `SELECT a.attname AS field, format_type(a.atttypid, NULL) AS type
+FROM pg_attribute AS a
+WHERE a.attrelid = :table::regclass AND a.attnum > 0 AND NOT a.attisdropped`
+	res, err := Me.WithScanMode(ScanString).Primary().QueryCtx(ctx, sql, map[string]interface{}{"table": KeyTable})
+	if err != nil {
+		return nil, err
+	}
+	KeyTypes := make(map[string]string, len(res))
+	for _, v := range res {
+		KeyTypes[v["field"].(string)] = v["type"].(string)
+	}
+	return KeyTypes, nil
 }
 
 // Update 数据操作3： 修改数据
 // 示例: err := Update("user" , map[string]interface{}{ "user_id":123,"user_name":"张三"} )
 func (Me ormPgsql) Update(mixTable string, row map[string]interface{}, conditions map[string]interface{}) error {
+	return Me.UpdateCtx(context.Background(), mixTable, row, conditions)
+}
+
+// UpdateCtx 数据操作3： 修改数据，可通过ctx取消或设置超时
+func (Me ormPgsql) UpdateCtx(ctx context.Context, mixTable string, row map[string]interface{}, conditions map[string]interface{}) error {
+	_, err := Me.UpdateCountCtx(ctx, mixTable, row, conditions)
+	return err
+}
+
+// UpdateCount 数据操作3： 修改数据，返回影响的行数
+// 示例: num, err := UpdateCount("user" , map[string]interface{}{ "user_name":"张三"}, map[string]interface{}{ "user_id":123} )
+func (Me ormPgsql) UpdateCount(mixTable string, row map[string]interface{}, conditions map[string]interface{}) (int64, error) {
+	return Me.UpdateCountCtx(context.Background(), mixTable, row, conditions)
+}
+
+// UpdateCountCtx 数据操作3： 修改数据，返回影响的行数，可通过ctx取消或设置超时
+func (Me ormPgsql) UpdateCountCtx(ctx context.Context, mixTable string, row map[string]interface{}, conditions map[string]interface{}) (int64, error) {
 	if Me.initErr {
 		log.Error("数据库未连接成功", Me.dbCfgName, Me.dbName)
-		return errors.New("数据库未连接成功:" + Me.dbCfgName + " . " + Me.dbName)
+		return 0, errors.New("数据库未连接成功:" + Me.dbCfgName + " . " + Me.dbName)
 	}
 
-	KeySql, KeyValues := Me.UtilUpdate(mixTable, row, conditions)
+	KeySql, KeyValues, err := Me.UtilUpdateE(mixTable, row, conditions)
+	if err != nil {
+		log.Error(err)
+		return 0, err
+	}
 
 	// 3、执行
-	if _, err := Me.o.Exec(UtilFormatExec(KeySql), KeyValues...); err != nil {
-		log.Error(err)
-		return err
+	return Me.execCountCtx(ctx, KeySql, KeyValues)
+}
+
+// UpdateReturning 数据操作3： 修改数据，返回修改后数据的指定字段，"*"为全部字段，不传时默认为"*"
+// 示例: rows, err := UpdateReturning("user" , map[string]interface{}{ "user_name":"张三"}, map[string]interface{}{ "user_id":123}, "user_id", "updated" )
+func (Me ormPgsql) UpdateReturning(mixTable string, row map[string]interface{}, conditions map[string]interface{}, fields ...string) ([]map[string]interface{}, error) {
+	return Me.UpdateReturningCtx(context.Background(), mixTable, row, conditions, fields...)
+}
+
+// UpdateReturningCtx 数据操作3： 修改数据，返回修改后数据的指定字段，可通过ctx取消或设置超时
+func (Me ormPgsql) UpdateReturningCtx(ctx context.Context, mixTable string, row map[string]interface{}, conditions map[string]interface{}, fields ...string) ([]map[string]interface{}, error) {
+	if Me.initErr {
+		log.Error("数据库未连接成功", Me.dbCfgName, Me.dbName)
+		return nil, errors.New("数据库未连接成功:" + Me.dbCfgName + " . " + Me.dbName)
 	}
 
-	return nil
+	KeySql, KeyValues, err := Me.UtilUpdateE(mixTable, row, conditions)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return Me.queryReturningCtx(ctx, KeySql, KeyValues, fields)
 }
 
 // Delete 数据操作5： 删除数据
 // 示例:	err := Delete("user" , map[string]interface{}{ "user_id":123} )
 func (Me ormPgsql) Delete(mixTable string, conditions map[string]interface{}) error {
+	return Me.DeleteCtx(context.Background(), mixTable, conditions)
+}
+
+// DeleteCtx 数据操作5： 删除数据，可通过ctx取消或设置超时
+func (Me ormPgsql) DeleteCtx(ctx context.Context, mixTable string, conditions map[string]interface{}) error {
+	_, err := Me.DeleteCountCtx(ctx, mixTable, conditions)
+	return err
+}
+
+// DeleteCount 数据操作5： 删除数据，返回影响的行数
+// 示例:	num, err := DeleteCount("user" , map[string]interface{}{ "user_id":123} )
+func (Me ormPgsql) DeleteCount(mixTable string, conditions map[string]interface{}) (int64, error) {
+	return Me.DeleteCountCtx(context.Background(), mixTable, conditions)
+}
+
+// DeleteCountCtx 数据操作5： 删除数据，返回影响的行数，可通过ctx取消或设置超时
+func (Me ormPgsql) DeleteCountCtx(ctx context.Context, mixTable string, conditions map[string]interface{}) (int64, error) {
 	if Me.initErr {
 		log.Error("数据库未连接成功", Me.dbCfgName, Me.dbName)
-		return errors.New("数据库未连接成功:" + Me.dbCfgName + " . " + Me.dbName)
+		return 0, errors.New("数据库未连接成功:" + Me.dbCfgName + " . " + Me.dbName)
 	}
 
-	KeySql, KeyValues := Me.UtilDelete(mixTable, conditions)
+	KeySql, KeyValues, err := Me.UtilDeleteE(mixTable, conditions)
+	if err != nil {
+		log.Error(err)
+		return 0, err
+	}
 
 	// 执行sql
-	if _, err := Me.o.Exec(UtilFormatExec(KeySql), KeyValues...); err != nil {
+	return Me.execCountCtx(ctx, KeySql, KeyValues)
+}
+
+// DeleteReturning 数据操作5： 删除数据，返回被删除数据的指定字段，"*"为全部字段，不传时默认为"*"
+// 示例:	rows, err := DeleteReturning("user" , map[string]interface{}{ "user_id":123} )
+func (Me ormPgsql) DeleteReturning(mixTable string, conditions map[string]interface{}, fields ...string) ([]map[string]interface{}, error) {
+	return Me.DeleteReturningCtx(context.Background(), mixTable, conditions, fields...)
+}
+
+// DeleteReturningCtx 数据操作5： 删除数据，返回被删除数据的指定字段，可通过ctx取消或设置超时
+func (Me ormPgsql) DeleteReturningCtx(ctx context.Context, mixTable string, conditions map[string]interface{}, fields ...string) ([]map[string]interface{}, error) {
+	if Me.initErr {
+		log.Error("数据库未连接成功", Me.dbCfgName, Me.dbName)
+		return nil, errors.New("数据库未连接成功:" + Me.dbCfgName + " . " + Me.dbName)
+	}
+
+	KeySql, KeyValues, err := Me.UtilDeleteE(mixTable, conditions)
+	if err != nil {
 		log.Error(err)
-		return err
+		return nil, err
 	}
+	return Me.queryReturningCtx(ctx, KeySql, KeyValues, fields)
+}
 
-	return nil
+// 执行修改或删除，返回影响的行数；设置了ExpectRows时，行数不一致则回滚并返回 *URowsAffectedError
+func (Me ormPgsql) execCountCtx(ctx context.Context, KeySql string, KeyValues []interface{}) (int64, error) {
+	run := func(db sqlExecutor) (int64, error) {
+		qSql, err := UtilFormatExecE(KeySql)
+		if err != nil {
+			return 0, err
+		}
+		res, err := db.ExecContext(ctx, qSql, KeyValues...)
+		if err != nil {
+			return 0, err
+		}
+		return res.RowsAffected()
+	}
+
+	// 不检查行数，直接执行
+	if Me.expectRows == nil {
+		num, err := run(Me.db())
+		if err != nil {
+			log.Error(err)
+			return 0, err
+		}
+		return num, nil
+	}
+
+	// 检查行数，在事务中执行
+	num := int64(0)
+	err := Me.TransactionCtx(ctx, func(tx *Tx) error {
+		var err error
+		if num, err = run(tx.db()); err != nil {
+			return err
+		}
+		if num != *Me.expectRows {
+			return &URowsAffectedError{Expected: *Me.expectRows, Actual: num}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error(err)
+		return num, err
+	}
+	return num, nil
+}
+
+// 执行带returning的修改或删除，返回数据；设置了ExpectRows时，行数不一致则回滚并返回 *URowsAffectedError
+func (Me ormPgsql) queryReturningCtx(ctx context.Context, KeySql string, KeyValues []interface{}, fields []string) ([]map[string]interface{}, error) {
+	if len(fields) == 0 {
+		fields = []string{"*"}
+	}
+	returning, err := utilReturningSql(fields)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	run := func(db sqlExecutor) ([]map[string]interface{}, error) {
+		qSql, err := UtilFormatExecE(KeySql + returning)
+		if err != nil {
+			return nil, err
+		}
+		List, err := db.QueryContext(ctx, qSql, KeyValues...)
+		if err != nil {
+			return nil, err
+		}
+		rows, err := utilScan(List, Me.scanMode)
+		_ = List.Close()
+		return rows, err
+	}
+
+	// 不检查行数，直接执行
+	if Me.expectRows == nil {
+		rows, err := run(Me.db())
+		if err != nil {
+			log.Error(err)
+			return nil, err
+		}
+		return rows, nil
+	}
+
+	// 检查行数，在事务中执行
+	var rows []map[string]interface{}
+	err = Me.TransactionCtx(ctx, func(tx *Tx) error {
+		var err error
+		if rows, err = run(tx.db()); err != nil {
+			return err
+		}
+		if int64(len(rows)) != *Me.expectRows {
+			return &URowsAffectedError{Expected: *Me.expectRows, Actual: int64(len(rows))}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return rows, nil
 }
 
 // Query 数据读取1： 常规读取(格式化sql)
@@ -172,66 +610,76 @@ func (Me ormPgsql) Delete(mixTable string, conditions map[string]interface{}) er
 //	    map[string]interface{}{ "teacher_id":teacher_id , "class_id":class_id } ,
 //	    map[string]interface{}{ "offset":1 , "limit":10 } ,
 //	)
+//
+// 说明：限制参数支持 group、order、limit、offset、for_update，详见 utilQuerySql；配置了只读库时从只读库读取，见 Primary；
+// 加行锁以及含 insert、update、delete、nextval 等写入关键字的语句使用主库(见 utilSqlReadOnly)，调用了有写操作的自定义函数时请使用 Primary()
 func (Me ormPgsql) Query(sql string, ConOpt ...map[string]interface{}) ([]map[string]interface{}, error) {
+	return Me.QueryCtx(context.Background(), sql, ConOpt...)
+}
+
+// QueryCtx 数据读取1： 常规读取(格式化sql)，可通过ctx取消或设置超时
+func (Me ormPgsql) QueryCtx(ctx context.Context, sql string, ConOpt ...map[string]interface{}) ([]map[string]interface{}, error) {
 	if Me.initErr {
 		log.Error("数据库未连接成功", Me.dbCfgName, Me.dbName)
 		return nil, errors.New("数据库未连接成功:" + Me.dbCfgName + " . " + Me.dbName)
 	}
 
-	// 1、条件参数和限制参数处理
-	KeyConditions := map[string]interface{}{}
-	if len(ConOpt) > 0 {
-		KeyConditions = ConOpt[0]
-	}
-	KeyOptions := map[string]interface{}{}
-	if len(ConOpt) > 1 {
-		KeyOptions = ConOpt[1]
-	}
-
-	// 2、sql整合
-	KeySql, KeyArgs := utilMakeCondition(sql, KeyConditions)
-	if _, ok := KeyOptions["limit"]; ok {
-		if _, ok := KeyOptions["offset"]; ok {
-			KeySql += " limit ?,?"
-			KeyArgs = append(KeyArgs, KeyOptions["offset"], KeyOptions["limit"])
-		} else {
-			KeySql += " limit ?"
-			KeyArgs = append(KeyArgs, KeyOptions["limit"])
-		}
+	// 1、条件参数和限制参数处理，sql整合
+	KeySql, KeyArgs, err := utilQuerySql(sql, ConOpt)
+	if err != nil {
+		log.Error(err)
+		return nil, err
 	}
 
-	// 3、读取的数据：从数据表里读取
-	KeyRows := make([]map[string]interface{}, 0)
-	if List, err := Me.o.Query(UtilFormatExec(KeySql), KeyArgs...); err != nil {
+	// 2、读取的数据：从数据表里读取，加行锁或不是只读语句时使用主库
+	KeyRows, err := Me.reader(utilHasLock(ConOpt) || !utilSqlReadOnly(KeySql)).queryMaps(ctx, KeySql, KeyArgs)
+	if err != nil {
 		log.Error(err)
 		return nil, err
-	} else {
-		// cols, _ := rows.Columns()
-		KeyRows, err = utilScan(List)
-		_ = List.Close()
-		if err != nil {
-			log.Error(err)
-			return nil, err
-		}
 	}
 
 	return KeyRows, nil
 }
 
+// 执行查询(参数占位为?)，返回全部数据
+func (Me ormPgsql) queryMaps(ctx context.Context, qSql string, qArgs []interface{}) ([]map[string]interface{}, error) {
+	qSql, err := UtilFormatExecE(qSql)
+	if err != nil {
+		return nil, err
+	}
+	List, err := Me.query(ctx, qSql, qArgs)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := utilScan(List, Me.scanMode)
+	_ = List.Close()
+	return rows, err
+}
+
 // QueryRaw 数据读取2： 常规读取(直接执行参数sql和参数)
 // 示例:	data,err:=QueryRow("select * from demo where id='123'")
 func (Me ormPgsql) QueryRaw(qSql string) ([]map[string]interface{}, error) {
+	return Me.QueryRawCtx(context.Background(), qSql)
+}
+
+// QueryRawCtx 数据读取2： 常规读取(直接执行参数sql和参数)，可通过ctx取消或设置超时
+func (Me ormPgsql) QueryRawCtx(ctx context.Context, qSql string) ([]map[string]interface{}, error) {
 	if Me.initErr {
 		log.Error("数据库未连接成功", Me.dbCfgName, Me.dbName)
 		return nil, errors.New("数据库未连接成功:" + Me.dbCfgName + " . " + Me.dbName)
 	}
 
-	List, err := Me.o.Query(UtilFormatExec(qSql))
+	qSql, err := UtilFormatExecE(qSql)
 	if err != nil {
 		log.Error(err)
 		return nil, err
 	}
-	rows, err := utilScan(List)
+	List, err := Me.db().QueryContext(ctx, qSql)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	rows, err := utilScan(List, Me.scanMode)
 	_ = List.Close()
 	if err != nil {
 		log.Error(err)
@@ -246,56 +694,35 @@ func (Me ormPgsql) QueryRaw(qSql string) ([]map[string]interface{}, error) {
 //
 //	Data,err := QueryTable("publish_homework_par_teacher" , "*",
 //	    map[string]interface{}{ "teacher_id":teacher_id , "class_id":class_id } ,
-//	    map[string]interface{}{ "offset":1 , "limit":10 } ,
+//	    map[string]interface{}{ "order":"id desc" , "offset":1 , "limit":10 } ,
 //	)
 func (Me ormPgsql) QueryTable(table string, fields string, ConOpt ...map[string]interface{}) ([]map[string]interface{}, error) {
+	return Me.QueryTableCtx(context.Background(), table, fields, ConOpt...)
+}
+
+// QueryTableCtx 数据读取3： 指定数据表读取一批数据，可通过ctx取消或设置超时
+func (Me ormPgsql) QueryTableCtx(ctx context.Context, table string, fields string, ConOpt ...map[string]interface{}) ([]map[string]interface{}, error) {
 	if Me.initErr {
 		log.Error("数据库未连接成功", Me.dbCfgName, Me.dbName)
 		return nil, errors.New("数据库未连接成功:" + Me.dbCfgName + " . " + Me.dbName)
 	}
 
-	// 默认参数
-	var (
-		conditions = map[string]interface{}{}
-		options    = map[string]interface{}{}
-	)
-	if len(ConOpt) > 0 {
-		conditions = ConOpt[0]
-	}
-	if len(ConOpt) > 1 {
-		options = ConOpt[1]
-	}
-	// 变量定义
-	var (
-		err error
-	)
-
-	Sql := "select " + fields + " from " + table + " where true "
-
-	//
-	for k := range conditions {
-		Sql += " and " + k + "=:" + k
+	qSql, qArgs, err := utilQueryTableSql(table, fields, ConOpt)
+	if err != nil {
+		log.Error(err)
+		return nil, err
 	}
 
-	qSql, qArgs := utilMakeCondition(Sql, conditions)
-
-	// 拼凑limit
-	if _, ok := options["limit"]; ok {
-		if _, ok := options["offset"]; ok {
-			qSql += " limit ?,?"
-			qArgs = append(qArgs, options["offset"], options["limit"])
-		} else {
-			qSql += " limit ?"
-			qArgs = append(qArgs, options["limit"])
-		}
+	if qSql, err = UtilFormatExecE(qSql); err != nil {
+		log.Error(err)
+		return nil, err
 	}
-
-	List, err := Me.o.Query(UtilFormatExec(qSql), qArgs...)
+	List, err := Me.reader(utilHasLock(ConOpt)).query(ctx, qSql, qArgs)
 	if err != nil {
 		log.Error(err)
 		return nil, err
 	}
-	rows, err := utilScan(List)
+	rows, err := utilScan(List, Me.scanMode)
 	_ = List.Close()
 	if err != nil {
 		log.Error(err)
@@ -314,6 +741,11 @@ func (Me ormPgsql) QueryTable(table string, fields string, ConOpt ...map[string]
 //
 // 说明：未找到，返回的数据体为nil
 func (Me ormPgsql) QueryTableOne(table string, fields string, Condition ...map[string]interface{}) (map[string]interface{}, error) {
+	return Me.QueryTableOneCtx(context.Background(), table, fields, Condition...)
+}
+
+// QueryTableOneCtx 数据读取4： 指定数据表读取一条数据，可通过ctx取消或设置超时
+func (Me ormPgsql) QueryTableOneCtx(ctx context.Context, table string, fields string, Condition ...map[string]interface{}) (map[string]interface{}, error) {
 	if Me.initErr {
 		log.Error("数据库未连接成功", Me.dbCfgName, Me.dbName)
 		return nil, errors.New("数据库未连接成功:" + Me.dbCfgName + " . " + Me.dbName)
@@ -332,7 +764,7 @@ func (Me ormPgsql) QueryTableOne(table string, fields string, Condition ...map[s
 
 	// 3、查询数据
 	var KeyRetData map[string]interface{} = nil
-	if data, err := Me.QueryTable(table, fields, KeyConditions, KeyOption); err != nil {
+	if data, err := Me.QueryTableCtx(ctx, table, fields, KeyConditions, KeyOption); err != nil {
 		return KeyRetData, err
 	} else {
 		// 有数据
@@ -416,7 +848,7 @@ func (Me ormPgsql) QueryTableOne(table string, fields string, Condition ...map[s
 //		log.Error(err)
 //		return "", err
 //	} else {
-//		rows, err := utilScan(List)
+//		rows, err := utilScan(List, Me.scanMode)
 //		_ = List.Close()
 //		if err != nil {
 //			log.Error(err)
@@ -431,6 +863,11 @@ func (Me ormPgsql) QueryTableOne(table string, fields string, Condition ...map[s
 
 // DescTable 表结构信息4：获取数据表字段信息
 func (Me ormPgsql) DescTable(tbName string) (map[string]UTbDesc, error) {
+	return Me.DescTableCtx(context.Background(), tbName)
+}
+
+// DescTableCtx 表结构信息4：获取数据表字段信息，可通过ctx取消或设置超时
+func (Me ormPgsql) DescTableCtx(ctx context.Context, tbName string) (map[string]UTbDesc, error) {
 	if Me.initErr {
 		log.Error("数据库未连接成功", Me.dbCfgName, Me.dbName)
 		return nil, errors.New("数据库未连接成功:" + Me.dbCfgName + " . " + Me.dbName)
@@ -450,8 +887,17 @@ LEFT JOIN (
 	  AND tc.table_name = c.table_name AND ccu.column_name = c.column_name
 	WHERE constraint_type = 'PRIMARY KEY'
 ) i ON i.Field=c.column_name AND i.table_schema=c.table_schema AND i.table_name=c.table_name
-WHERE c.TABLE_NAME = :name2 AND c.table_schema='public'`
-	if res, err := Me.Query(sql, map[string]interface{}{"name1": tbName, "name2": tbName}); err != nil {
+WHERE c.TABLE_NAME = :name2 AND c.table_schema=:schema`
+	parts, err := utilSplitTable(tbName)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	schema, name := "public", utilUnquoteIdent(parts[0])
+	if len(parts) == 2 {
+		schema, name = name, utilUnquoteIdent(parts[1])
+	}
+	if res, err := Me.WithScanMode(ScanString).QueryCtx(ctx, sql, map[string]interface{}{"name2": name, "schema": schema}); err != nil {
 		log.Error(err)
 		return nil, err
 	} else {
@@ -477,13 +923,23 @@ WHERE c.TABLE_NAME = :name2 AND c.table_schema='public'`
 // Exec 特殊1：直接执行sql
 // 示例: err := Exec("alter table user rename user_old" )
 func (Me ormPgsql) Exec(Sql string) error {
+	return Me.ExecCtx(context.Background(), Sql)
+}
+
+// ExecCtx 特殊1：直接执行sql，可通过ctx取消或设置超时
+func (Me ormPgsql) ExecCtx(ctx context.Context, Sql string) error {
 	if Me.initErr {
 		log.Error("数据库未连接成功", Me.dbCfgName, Me.dbName)
 		return errors.New("数据库未连接成功:" + Me.dbCfgName + " . " + Me.dbName)
 	}
 
 	// 执行sql
-	if _, err := Me.o.Exec(UtilFormatExec(Sql)); err != nil {
+	Sql, err := UtilFormatExecE(Sql)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	if _, err := Me.db().ExecContext(ctx, Sql); err != nil {
 		log.Error(err)
 		return err
 	}
@@ -491,7 +947,7 @@ func (Me ormPgsql) Exec(Sql string) error {
 	return nil
 }
 
-// QueryAllCircle 特殊2：mysql中获取全表数据
+// QueryAllCircle 特殊2：按排序字段分批获取全表数据
 //
 //	err := QueryAllCircle(mysql_v1.UFastQuery{
 //		Table:           "tbl_resource_main",
@@ -499,202 +955,310 @@ func (Me ormPgsql) Exec(Sql string) error {
 //		PriField:        "id",
 //		PriSort:         "asc",
 //		RowLimit:        2000,
+//		// OrderFields:  []string{"updated", "id"},
+//		// Where:        map[string]interface{}{"status": 1},
 //		// BeginVal:        3,
 //		// beginValIgnore: true,
 //	},func(data map[string]interface{}) bool{
 //		fmt.Println(len(data))
 //		return true	// true:继续 false：终止
 //	})
+//
+// 说明：每批使用 where (a,b) > (上一批最后一行的a,b) order by a,b limit n 读取，排序字段需要有对应的索引；
+// 设置Checkpoint时每批回调完成后保存断点，Resume为true时从断点继续读取，中断重启后最多重复回调一批数据
+// 配置了只读库时从同一个只读库读取各批数据
 func (Me ormPgsql) QueryAllCircle(Cfg UFastQuery, backFunc func(V map[string]interface{}) bool) error {
+	return Me.QueryAllCircleCtx(context.Background(), Cfg, backFunc)
+}
+
+// QueryAllCircleCtx 特殊2：获取全表数据，可通过ctx取消或设置超时，取消后在读取下一批前返回ctx的错误
+func (Me ormPgsql) QueryAllCircleCtx(ctx context.Context, Cfg UFastQuery, backFunc func(V map[string]interface{}) bool) error {
+	return Me.QueryAllCircleBatchCtx(ctx, Cfg, utilCircleRows(backFunc))
+}
+
+// QueryAllCircleBatch 特殊2：按排序字段分批获取全表数据，每批数据回调一次，参数同QueryAllCircle
+// 示例:
+//
+//	err := QueryAllCircleBatch(pgsql_v1.UFastQuery{
+//		Table:    "demo",
+//		Fields:   "*",
+//		PriField: "id",
+//		RowLimit: 2000,
+//		Progress: func(p pgsql_v1.UProgress) { fmt.Println(p.Rows, p.Batches, p.Total) },
+//	}, func(rows []map[string]interface{}) error {
+//		_, err := pgsql_v1.Handle("backup").UpsertMany("demo", rows, []string{"id"}, []string{"*"})
+//		return err // 返回 pgsql_v1.ErrStop 停止读取，返回其他错误时停止并返回该错误
+//	})
+func (Me ormPgsql) QueryAllCircleBatch(Cfg UFastQuery, batchFunc func(rows []map[string]interface{}) error) error {
+	return Me.QueryAllCircleBatchCtx(context.Background(), Cfg, batchFunc)
+}
+
+// QueryAllCircleBatchCtx 特殊2：按排序字段分批获取全表数据，每批数据回调一次，可通过ctx取消或设置超时
+func (Me ormPgsql) QueryAllCircleBatchCtx(ctx context.Context, Cfg UFastQuery, batchFunc func(rows []map[string]interface{}) error) error {
 	if Me.initErr {
 		log.Error("数据库未连接成功", Me.dbCfgName, Me.dbName)
 		return errors.New("数据库未连接成功:" + Me.dbCfgName + " . " + Me.dbName)
 	}
 
-	var (
-		table          = Cfg.Table          // 表名
-		fields         = Cfg.Fields         // 检索的字段
-		priField       = Cfg.PriField       // 主键字段名
-		priSort        = Cfg.PriSort        // 顺序 asc/desc
-		rowLimit       = Cfg.RowLimit       // 单词取出行数
-		beginVal       = Cfg.BeginVal       // 最后一次取出标记，
-		beginValIgnore = Cfg.BeginValIgnore // 默认包含beginVal起点数据，设置为true，则忽略beginVal起点数据
-		compare        = ">"
-		Sql            = ""
-		groupNum       = 0
-		// priFieldIsString = true
-	)
-
-	// 获取表结构，识别主键类型
-	dbDesc, err := Me.DescTable(Cfg.Table)
+	// 1、生成分批读取的sql
+	circle, err := utilCircleSql(Cfg)
 	if err != nil {
 		log.Error(err)
 		return err
 	}
-	if _, ok := dbDesc[Cfg.PriField]; !ok {
-		return errors.New(Cfg.PriField + " 不是主键")
-	}
-	if !dbDesc[Cfg.PriField].IsPri {
-		return errors.New(Cfg.PriField + " 不是主键")
+
+	// 2、检查排序字段包含主键或某个唯一索引，保证顺序唯一
+	if err := Me.checkUniqueOrderCtx(ctx, Cfg.Table, circle.orders); err != nil {
+		log.Error(err)
+		return err
 	}
-	//if dbDesc[Cfg.PriField].Type == "int" || dbDesc[Cfg.PriField].Type == "bigint" {
-	//	priFieldIsString = false
-	//}
 
-	// 取出起点
-	if beginVal == nil {
-		res, err := Me.Query("select "+priField+" from "+table+" order by "+priField+" "+priSort,
-			map[string]interface{}{}, map[string]interface{}{
-				"limit": 1,
-			},
-		)
+	// 3、从断点继续读取
+	beginVals, include := circle.beginVals, !Cfg.BeginValIgnore
+	if Cfg.Resume && Cfg.Checkpoint != nil {
+		vals, err := Cfg.Checkpoint.Load(ctx)
 		if err != nil {
 			log.Error(err)
 			return err
 		}
-		// 没有数据
-		if len(res) == 0 {
-			return nil
-		}
-		beginVal = res[0][priField]
-	}
-
-	// 1、读取数据
-	if priSort == "desc" {
-		compare = "<"
-	}
-	for {
-		// 1.1、拼凑mysql
-		if groupNum == 0 && !beginValIgnore {
-			if priSort == "desc" {
-				compare = "<="
-			} else {
-				compare = ">="
+		if vals != nil {
+			if len(vals) != len(circle.orders) {
+				err := errors.New("断点的个数和排序字段不一致")
+				log.Error(err)
+				return err
 			}
-		} else if groupNum == 1 {
-			if priSort == "desc" {
-				compare = "<"
-			} else {
-				compare = ">"
+			beginVals, include = make([]interface{}, len(vals)), false
+			for i, v := range vals {
+				beginVals[i] = v
 			}
 		}
-		if groupNum <= 1 {
-			Sql = `
-		        select ` + fields + `,` + priField + ` from ` + table + `
-		        where ` + priField + compare + `:` + priField + `
-		        order by ` + priField + ` ` + priSort
-		}
-		// 1.2、读取数据
-		maps, err := Me.Query(Sql,
-			map[string]interface{}{
-				priField: beginVal,
-			}, map[string]interface{}{
-				"limit": rowLimit,
-			},
-		)
+	}
+
+	// 4、进度：回调完成后报告已回调的行数、批数和预计总行数
+	if Cfg.Progress != nil {
+		total, err := Me.estimateRowsCtx(ctx, Cfg.Table)
 		if err != nil {
 			log.Error(err)
 			return err
 		}
-
-		// 1.3、刷新 mysqlTablePriIdVal
-		rowNum := len(maps)
-		if rowNum > 0 {
-			beginVal = maps[len(maps)-1][priField]
-		}
-		// 1.4、数据回调,返回为false则停止读取
-		_continue := true
-		for _, v := range maps {
-			if !backFunc(v) {
-				_continue = false
-				break
+		progress, next := UProgress{Total: total}, batchFunc
+		batchFunc = func(rows []map[string]interface{}) error {
+			if err := next(rows); err != nil {
+				return err
 			}
-		}
-		if !_continue {
+			progress.Rows += int64(len(rows))
+			progress.Batches++
+			Cfg.Progress(progress)
 			return nil
 		}
-		groupNum++
-
-		if rowNum < rowLimit {
-			break
-		}
 	}
-	return nil
+
+	// 5、读取数据，各批使用同一个只读库
+	return Me.reader(false).circleScanCtx(ctx, circle, beginVals, include, Cfg.Checkpoint, batchFunc)
 }
 
 // GetDb 特殊3：获取句柄,外部要执行大型事务等场合用
 func (Me ormPgsql) GetDb() *sql.DB {
-	return Me.o
+	return Me.pool.get()
+}
+
+// WithScanMode 特殊4：返回指定查询结果转换模式的句柄，ScanString(默认)所有列转换成字符串，ScanTyped按列类型返回
+// 示例: data, err := Handle().WithScanMode(pgsql_v1.ScanTyped).Query("select * from demo")
+func (Me ormPgsql) WithScanMode(mode string) *ormPgsql {
+	Me.scanMode = mode
+	return &Me
+}
+
+// ExpectRows 特殊5：返回检查影响行数的句柄，之后的 Update/Delete 系列函数影响的行数不等于n时回滚，并返回 *URowsAffectedError
+// 示例: err := Handle().ExpectRows(1).Update("user", map[string]interface{}{"user_name": "张三"}, map[string]interface{}{"user_id": 123})
+func (Me ormPgsql) ExpectRows(n int64) *ormPgsql {
+	Me.expectRows = &n
+	return &Me
+}
+
+// Primary 特殊8：返回读取也使用主库的句柄，配置了只读库(replicas)时，需要读到刚写入的数据等场合用
+// 示例: data, err := Handle().Primary().Query("select * from demo where id = :id", map[string]interface{}{"id": id})
+func (Me ormPgsql) Primary() *ormPgsql {
+	Me.primary = true
+	return &Me
 }
 
-// UtilInsert 获取insert的sql和参数
+// 读取使用的句柄：配置了只读库时固定选择一个只读库；事务中、Primary()之后或usePrimary为true时使用主库
+func (Me ormPgsql) reader(usePrimary bool) ormPgsql {
+	if Me.tx != nil || Me.primary || usePrimary || Me.readIdx > 0 {
+		return Me
+	}
+	Me.readIdx = Me.pool.pick() + 1
+	return Me
+}
+
+// 执行读取，参数占位已转换成$n；只读库连接失败时暂停使用该只读库，改用主库重试
+func (Me ormPgsql) query(ctx context.Context, qSql string, qArgs []interface{}) (*sql.Rows, error) {
+	dbHandle := Me.db()
+	List, err := dbHandle.QueryContext(ctx, qSql, qArgs...)
+	if err != nil && Me.tx == nil && Me.readIdx > 0 && dbHandle != sqlExecutor(Me.pool.get()) && ctx.Err() == nil && utilIsConnError(err) {
+		log.Warn("只读库连接失败，暂停使用并改用主库: " + err.Error())
+		Me.pool.markDown(Me.readIdx - 1)
+		return Me.pool.get().QueryContext(ctx, qSql, qArgs...)
+	}
+	return List, err
+}
+
+// UtilInsert 获取insert的sql和参数，出错时记录错误并返回空sql，需要错误时使用 UtilInsertE
 func (Me ormPgsql) UtilInsert(table string, row map[string]interface{}) (string, []interface{}) {
-	// 1、条件参数：从参数里拼凑
+	KeySql, KeyValues, err := Me.UtilInsertE(table, row)
+	if err != nil {
+		log.Error(err)
+		return "", nil
+	}
+	return KeySql, KeyValues
+}
+
+// UtilInsertE 获取insert的sql和参数
+// 表名和字段名都加上双引号，非法的标识符返回错误；row为空时为 insert into 表名 default values
+func (Me ormPgsql) UtilInsertE(table string, row map[string]interface{}) (string, []interface{}, error) {
+	// 1、数据表名
+	KeyTable, err := UtilQuoteTable(table)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// 2、条件参数：从参数里拼凑
 	KeyFields := make([]string, 0)
 	KeyFieldFlag := make([]string, 0)
 	KeyValues := make([]interface{}, 0)
-	for k, v := range row {
-		KeyFields = append(KeyFields, ""+k+"")
+	for _, k := range utilSortedKeys(row) {
+		field, err := UtilQuoteIdent(k)
+		if err != nil {
+			return "", nil, err
+		}
+		KeyFields = append(KeyFields, field)
 		KeyFieldFlag = append(KeyFieldFlag, "?")
-		KeyValues = append(KeyValues, v)
+		KeyValues = append(KeyValues, row[k])
 	}
 
-	// 2、写入后数据的自增Id：写入数据后数据库生成的
+	// 3、拼凑sql，没有字段时全部使用默认值
+	if len(KeyFields) == 0 {
+		return `
+		insert into ` + KeyTable + ` default values`, KeyValues, nil
+	}
 	KeySql := `
-		insert into ` + table + `(` + strings.Join(KeyFields, ",") + `)
+		insert into ` + KeyTable + `(` + strings.Join(KeyFields, ",") + `)
 		values (` + strings.Join(KeyFieldFlag, ",") + `)`
-	return KeySql, KeyValues
+	return KeySql, KeyValues, nil
 }
 
-// UtilUpdate 获取update的sql和参数
+// UtilUpdate 获取update的sql和参数，出错时记录错误并返回空sql，需要错误时使用 UtilUpdateE
 func (Me ormPgsql) UtilUpdate(mixTable string, row map[string]interface{}, conditions map[string]interface{}) (string, []interface{}) {
-	// 1、参数拼凑
-	KeyConditionFields := make([]string, 0)
-	KeyUpdateFields := make([]string, 0)
-	KeyValues := make([]interface{}, 0)
-	for k, v := range row {
-		KeyUpdateFields = append(KeyUpdateFields, ""+k+"=?")
-		KeyValues = append(KeyValues, v)
+	KeySql, KeyValues, err := Me.UtilUpdateE(mixTable, row, conditions)
+	if err != nil {
+		log.Error(err)
+		return "", nil
 	}
-	for k, v := range conditions {
-		KeyConditionFields = append(KeyConditionFields, ""+k+"=?")
-		KeyValues = append(KeyValues, v)
+	return KeySql, KeyValues
+}
+
+// UtilUpdateE 获取update的sql和参数
+// 表名和字段名都加上双引号，非法的标识符返回错误；条件格式见 utilBuildWhere，条件为空时返回错误
+func (Me ormPgsql) UtilUpdateE(mixTable string, row map[string]interface{}, conditions map[string]interface{}) (string, []interface{}, error) {
+	// 1、数据表名处理
+	KeyTable, err := UtilQuoteTable(mixTable)
+	if err != nil {
+		return "", nil, err
 	}
 
-	// 2、数据表名处理
-	KeyTable := "" + mixTable + ""
-	if strings.Contains(mixTable, ".") {
-		KeyTable = mixTable
+	// 2、参数拼凑
+	if len(conditions) == 0 {
+		return "", nil, errors.New("修改数据的条件不能为空")
+	}
+	KeyUpdateFields := make([]string, 0)
+	KeyValues := make([]interface{}, 0)
+	for _, k := range utilSortedKeys(row) {
+		field, err := UtilQuoteIdent(k)
+		if err != nil {
+			return "", nil, err
+		}
+		KeyUpdateFields = append(KeyUpdateFields, field+"=?")
+		KeyValues = append(KeyValues, row[k])
 	}
+	KeyWhere, KeyArgs, err := utilBuildWhere(conditions)
+	if err != nil {
+		return "", nil, err
+	}
+	KeyValues = append(KeyValues, KeyArgs...)
 
-	// 3、执行
+	// 3、拼凑sql
 	KeySql := `
 			update ` + KeyTable + `
 			set ` + strings.Join(KeyUpdateFields, ",") + `
-			where ` + strings.Join(KeyConditionFields, " and ") + `
+			where ` + KeyWhere + `
 		`
-	return KeySql, KeyValues
+	return KeySql, KeyValues, nil
 }
 
-// UtilDelete 获取delete的sql和参数
+// UtilDelete 获取delete的sql和参数，出错时记录错误并返回空sql，需要错误时使用 UtilDeleteE
 func (Me ormPgsql) UtilDelete(mixTable string, conditions map[string]interface{}) (string, []interface{}) {
+	KeySql, KeyValues, err := Me.UtilDeleteE(mixTable, conditions)
+	if err != nil {
+		log.Error(err)
+		return "", nil
+	}
+	return KeySql, KeyValues
+}
+
+// UtilDeleteE 获取delete的sql和参数
+// 表名和字段名都加上双引号，非法的标识符返回错误；条件格式见 utilBuildWhere，条件为空时返回错误
+func (Me ormPgsql) UtilDeleteE(mixTable string, conditions map[string]interface{}) (string, []interface{}, error) {
+	// 数据表名
+	table, err := UtilQuoteTable(mixTable)
+	if err != nil {
+		return "", nil, err
+	}
 
 	// 拼凑sql
-	var (
-		fields []string
-		values []interface{}
-	)
-	for k, v := range conditions {
-		fields = append(fields, ""+k+"=?")
-		values = append(values, v)
+	if len(conditions) == 0 {
+		return "", nil, errors.New("删除数据的条件不能为空")
+	}
+	where, values, err := utilBuildWhere(conditions)
+	if err != nil {
+		return "", nil, err
+	}
+	Sql := "delete from " + table + " where " + where
+
+	return Sql, values, nil
+}
+
+// UtilQuoteIdent 标识符加上双引号，内部的双引号转义；已加双引号的原样返回
+// 空字符串、包含\0、超过63字节(PostgreSQL会截断)的标识符返回错误
+// 示例: UtilQuoteIdent("order") => "order" (带双引号)
+func UtilQuoteIdent(name string) (string, error) {
+	// 已加双引号的，去掉后重新处理
+	name = utilUnquoteIdent(name)
+	if name == "" {
+		return "", errors.New("标识符不能为空")
 	}
-	table := "" + mixTable + ""
-	if strings.Contains(mixTable, ".") {
-		table = mixTable
+	if strings.IndexByte(name, 0) >= 0 {
+		return "", errors.New("标识符不能包含\\0: " + strconv.Quote(name))
 	}
-	Sql := "delete from " + table + " where " + strings.Join(fields, " and ")
+	if len(name) > 63 {
+		return "", errors.New("标识符超过63字节: " + name)
+	}
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`, nil
+}
 
-	return Sql, values
+// UtilQuoteTable 表名加上双引号，schema.table 拆分成两部分分别处理，各部分可以已加双引号
+// 示例: UtilQuoteTable("public.user") => "public"."user" (带双引号)
+func UtilQuoteTable(table string) (string, error) {
+	parts, err := utilSplitTable(table)
+	if err != nil {
+		return "", err
+	}
+	for i, v := range parts {
+		if parts[i], err = UtilQuoteIdent(v); err != nil {
+			return "", err
+		}
+	}
+	return strings.Join(parts, "."), nil
 }
 
 // 辅助函数1: sql条件拼凑处理
@@ -703,80 +1267,491 @@ func (Me ormPgsql) UtilDelete(mixTable string, conditions map[string]interface{}
 //	Sql,Args := utilMakeCondition("select * from publish_homework_par_teacher where teacher_id=:teacher_id and class_id=:class_id" ,
 //	    map[string]interface{}{ "teacher_id":teacher_id , "class_id":class_id }
 //	)
-func utilMakeCondition(sql string, conditions map[string]interface{}) (string, []interface{}) {
-	// 查找连续的单词字母
+//
+// 说明：字符串、注释、带引号的标识符和$$函数体里的内容不处理，id::text 这类类型转换不作为参数；
+// 缺少参数、in列表参数不是切片或为空、?/:name 和 $n 混用时返回错误
+func utilMakeCondition(sql string, conditions map[string]interface{}) (string, []interface{}, error) {
+	tokens := utilSqlTokens(sql)
+	if err := utilSqlCheckMixed(sql, tokens); err != nil {
+		return "", nil, err
+	}
 	KeyArgs := make([]interface{}, 0)
-	KeySql := regexp.MustCompile(`:[:]?[\w]+`).ReplaceAllStringFunc(sql, func(s string) string {
-		// ::符号，针对in方式连续变量处理
-		if s[:2] == "::" {
-			qMarkArr := make([]string, 0)
-			for _, v := range conditions[s[2:]].([]interface{}) {
-				KeyArgs = append(KeyArgs, v)
+	KeySql := strings.Builder{}
+	for _, t := range tokens {
+		switch t.kind {
+		// :name 命名参数
+		case sqlTokNamed:
+			v, ok := conditions[t.name]
+			if !ok {
+				return "", nil, errors.New("缺少参数: " + t.name)
+			}
+			KeyArgs = append(KeyArgs, v)
+			KeySql.WriteString("?")
+
+		// ::name 针对in方式连续变量处理
+		case sqlTokList:
+			v, ok := conditions[t.name]
+			if !ok {
+				return "", nil, errors.New("缺少参数: " + t.name)
+			}
+			rv := reflect.ValueOf(v)
+			if v == nil || (rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array) || rv.Type().Elem().Kind() == reflect.Uint8 {
+				return "", nil, fmt.Errorf("参数 %s 必须是切片，实际为: %T", t.name, v)
+			}
+			if rv.Len() == 0 {
+				return "", nil, errors.New("参数 " + t.name + " 不能为空切片")
+			}
+			qMarkArr := make([]string, 0, rv.Len())
+			for k := 0; k < rv.Len(); k++ {
+				KeyArgs = append(KeyArgs, rv.Index(k).Interface())
 				qMarkArr = append(qMarkArr, "?")
 			}
-			return strings.Join(qMarkArr, ",")
+			KeySql.WriteString(strings.Join(qMarkArr, ","))
+
+		default:
+			KeySql.WriteString(t.text)
 		}
-		KeyArgs = append(KeyArgs, conditions[s[1:]])
-		return "?"
-	})
+	}
 
-	return KeySql, KeyArgs
+	return KeySql.String(), KeyArgs, nil
 }
 
-// 辅助函数2: mysql查询结果数据转换成map数组数据
-func utilScan(List *sql.Rows) ([]map[string]interface{}, error) {
+// 辅助函数2: 查询结果数据转换成map数组数据，scanMode为ScanTyped时按列类型返回，否则都转换成字符串
+func utilScan(List *sql.Rows, scanMode string) ([]map[string]interface{}, error) {
 	fields, _ := List.Columns()
 	rows := make([]map[string]interface{}, 0)
 
+	// 按列类型返回时读取列类型
+	var types []*sql.ColumnType
+	if scanMode == ScanTyped {
+		var err error
+		if types, err = List.ColumnTypes(); err != nil {
+			return nil, err
+		}
+	}
+
 	// 遍历数据
 	for List.Next() {
-		// 内容数据scans：从list中提取
-		scans := make([]interface{}, len(fields))
-		for i := range scans {
-			scans[i] = &scans[i]
-		}
-		err := List.Scan(scans...)
+		row, err := utilScanRow(List, fields, types)
 		if err != nil {
 			return nil, err
 		}
-
-		// 一行数据row：从scans里提取
-		row := make(map[string]interface{})
-		for i, v := range scans {
-			var value interface{}
-			switch inst := v.(type) {
-			case nil:
-				value = nil
-			case int64:
-				value = strconv.FormatInt(inst, 10)
-			case int:
-				value = strconv.Itoa(inst)
-			case []byte:
-				value = string(inst)
-			case float64:
-				value = strconv.FormatFloat(inst, 'E', -1, 64)
-			case time.Time:
-				value = v.(time.Time).String()
-			case string:
-				value = v
-			default:
-				value = v
-				fmt.Println("未知类型:", reflect.TypeOf(v))
-				log.Panic(fields[i], " default")
-			}
-			row[fields[i]] = value
-		}
 		rows = append(rows, row)
 	}
-	return rows, nil
+	return rows, List.Err()
 }
 
 // UtilFormatExec 辅助函数3: sql中的?号替换成$x
+// 字符串、注释、带引号的标识符和$$函数体里的?不替换；出现在表达式之后且其后是字符串、?、:name、$n的?以及?|、?&视为jsonb运算符不替换；
+// ?? 输出为 ? 运算符，用于无法自动识别的场合；?和$n混用时记录错误并返回空字符串，需要错误时使用 UtilFormatExecE
 func UtilFormatExec(sql string) string {
-	i := 0
-	KeySql := regexp.MustCompile(`[?]`).ReplaceAllStringFunc(sql, func(s string) string {
-		i += 1
-		return "$" + strconv.Itoa(i)
-	})
+	KeySql, err := UtilFormatExecE(sql)
+	if err != nil {
+		log.Error(err)
+		return ""
+	}
 	return KeySql
 }
+
+// UtilFormatExecE 辅助函数3: sql中的?号替换成$x，规则同 UtilFormatExec；?、:name 和 $n 混用时返回错误
+func UtilFormatExecE(sql string) (string, error) {
+	tokens := utilSqlTokens(sql)
+	if err := utilSqlCheckMixed(sql, tokens); err != nil {
+		return "", err
+	}
+	i := 0
+	KeySql := strings.Builder{}
+	for _, t := range tokens {
+		switch t.kind {
+		case sqlTokPlaceholder:
+			i += 1
+			KeySql.WriteString("$" + strconv.Itoa(i))
+		case sqlTokQuestion:
+			KeySql.WriteString("?")
+		default:
+			KeySql.WriteString(t.text)
+		}
+	}
+	return KeySql.String(), nil
+}
+
+// 辅助函数4: Query的sql拼凑，ConOpt第一个为条件参数，第二个为限制参数，限制参数见 utilMakeOptions
+func utilQuerySql(sql string, ConOpt []map[string]interface{}) (string, []interface{}, error) {
+	// 1、条件参数和限制参数
+	KeyConditions := map[string]interface{}{}
+	if len(ConOpt) > 0 {
+		KeyConditions = ConOpt[0]
+	}
+	KeyOptions := map[string]interface{}{}
+	if len(ConOpt) > 1 {
+		KeyOptions = ConOpt[1]
+	}
+
+	// 2、sql整合
+	KeySql, KeyArgs, err := utilMakeCondition(sql, KeyConditions)
+	if err != nil {
+		return "", nil, err
+	}
+	return utilMakeOptions(KeySql, KeyArgs, KeyOptions)
+}
+
+// 辅助函数5: 限制参数拼凑到sql后面
+// 限制参数支持：
+//
+//	group:      分组，字符串(逗号分隔)或字符串切片，如 "class_id" / []string{"class_id","status"}
+//	order:      排序，字符串(逗号分隔)或字符串切片，每项为 字段 [asc|desc] [nulls first|last]，如 "id desc" / []string{"status","t.id desc nulls last"}
+//	            字段名加上双引号，其他写法返回错误；表达式使用 URaw，如 pgsql_v1.URaw{Sql: "count(*) desc"}
+//	limit:      读取条数
+//	offset:     跳过条数
+//	for_update: 加行锁，true 为 "for update"，字符串时追加在其后，只能是 "nowait" / "skip locked"
+func utilMakeOptions(KeySql string, KeyArgs []interface{}, KeyOptions map[string]interface{}) (string, []interface{}, error) {
+	// 1、group by 和 order by：字段名加上双引号，URaw 原样使用
+	for _, o := range [][2]string{{"group", " group by "}, {"order", " order by "}} {
+		v, ok := KeyOptions[o[0]]
+		if !ok {
+			continue
+		}
+		items, args, err := utilOrderItems(o[0], v)
+		if err != nil {
+			return "", nil, err
+		}
+		if items != "" {
+			KeySql += o[1] + items
+			KeyArgs = append(KeyArgs, args...)
+		}
+	}
+
+	// 2、limit 和 offset
+	if v, ok := KeyOptions["limit"]; ok {
+		KeySql += " limit ?"
+		KeyArgs = append(KeyArgs, v)
+	}
+	if v, ok := KeyOptions["offset"]; ok {
+		KeySql += " offset ?"
+		KeyArgs = append(KeyArgs, v)
+	}
+
+	// 3、行锁
+	if v, ok := KeyOptions["for_update"]; ok {
+		switch inst := v.(type) {
+		case bool:
+			if inst {
+				KeySql += " for update"
+			}
+		case string:
+			option, err := utilLockOption(inst)
+			if err != nil {
+				return "", nil, err
+			}
+			KeySql += strings.TrimRight(" for update "+option, " ")
+		default:
+			return "", nil, errors.New("for_update 参数必须是布尔值或字符串")
+		}
+	}
+	return KeySql, KeyArgs, nil
+}
+
+// 辅助函数6: QueryTable的sql拼凑，ConOpt第一个为条件参数，第二个为限制参数
+// 表名和条件字段名加上双引号，fields原样使用；条件格式见 utilBuildWhere
+func utilQueryTableSql(table string, fields string, ConOpt []map[string]interface{}) (string, []interface{}, error) {
+	// 1、条件参数和限制参数
+	conditions := map[string]interface{}{}
+	if len(ConOpt) > 0 {
+		conditions = ConOpt[0]
+	}
+	options := map[string]interface{}{}
+	if len(ConOpt) > 1 {
+		options = ConOpt[1]
+	}
+
+	// 2、数据表名
+	KeyTable, err := UtilQuoteTable(table)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// 3、拼凑条件
+	where, Args, err := utilBuildWhere(conditions)
+	if err != nil {
+		return "", nil, err
+	}
+	Sql := "select " + fields + " from " + KeyTable + " where " + where
+
+	// 4、limit等限制参数
+	return utilMakeOptions(Sql, Args, options)
+}
+
+// 辅助函数7: 按数据库列类型转换成Go原生类型
+// 整数 int64，浮点 float64，布尔 bool，日期时间 time.Time，bytea []byte，其他(numeric/uuid/json/文本等)为 string
+func utilTypedValue(v interface{}, dbType string) interface{} {
+	b, ok := v.([]byte)
+	if !ok {
+		return v
+	}
+	switch dbType {
+	case "BYTEA":
+		return b
+	case "INT2", "INT4", "INT8", "OID":
+		if n, err := strconv.ParseInt(string(b), 10, 64); err == nil {
+			return n
+		}
+	case "FLOAT4", "FLOAT8":
+		if f, err := strconv.ParseFloat(string(b), 64); err == nil {
+			return f
+		}
+	case "BOOL":
+		if t, err := strconv.ParseBool(string(b)); err == nil {
+			return t
+		}
+	}
+	return string(b)
+}
+
+// 辅助函数8: 拆分 schema.table，双引号里的点不拆分，最多两部分
+func utilSplitTable(table string) ([]string, error) {
+	parts := utilSplitDotted(table)
+	if len(parts) > 2 {
+		return nil, errors.New("非法的表名，只支持 schema.table 格式: " + table)
+	}
+	return parts, nil
+}
+
+// 辅助函数11: 按点拆分，双引号里的点不拆分
+func utilSplitDotted(name string) []string {
+	parts := make([]string, 0, 2)
+	start := 0
+	for i := 0; i < len(name); i++ {
+		if name[i] == '"' {
+			i = utilSqlSkipQuoted(name, i, '"', false) - 1
+		} else if name[i] == '.' {
+			parts = append(parts, name[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, name[start:])
+}
+
+// 辅助函数9: map的键名排序，使生成的sql固定
+func utilSortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// 辅助函数10: 去掉标识符两端的双引号，没有双引号的原样返回
+func utilUnquoteIdent(name string) string {
+	if len(name) >= 2 && name[0] == '"' && name[len(name)-1] == '"' && utilSqlSkipQuoted(name, 0, '"', false) == len(name) {
+		return strings.ReplaceAll(name[1:len(name)-1], `""`, `"`)
+	}
+	return name
+}
+
+// 辅助函数12: 当前行数据转换成map，types不为nil时按列类型返回，否则都转换成字符串，不支持的类型返回错误
+func utilScanRow(List *sql.Rows, fields []string, types []*sql.ColumnType) (map[string]interface{}, error) {
+	// 内容数据scans：从list中提取
+	scans := make([]interface{}, len(fields))
+	for i := range scans {
+		scans[i] = &scans[i]
+	}
+	if err := List.Scan(scans...); err != nil {
+		return nil, err
+	}
+
+	// 按列类型返回
+	row := make(map[string]interface{}, len(fields))
+	if types != nil {
+		for i, v := range scans {
+			row[fields[i]] = utilTypedValue(v, types[i].DatabaseTypeName())
+		}
+		return row, nil
+	}
+
+	// 一行数据row：从scans里提取
+	for i, v := range scans {
+		var value interface{}
+		switch inst := v.(type) {
+		case nil:
+			value = nil
+		case int64:
+			value = strconv.FormatInt(inst, 10)
+		case int:
+			value = strconv.Itoa(inst)
+		case []byte:
+			value = string(inst)
+		case float64:
+			value = strconv.FormatFloat(inst, 'E', -1, 64)
+		case time.Time:
+			value = v.(time.Time).String()
+		case string:
+			value = v
+		case bool:
+			value = strconv.FormatBool(inst)
+		default:
+			return nil, fmt.Errorf("字段 %s 的类型不支持: %T", fields[i], v)
+		}
+		row[fields[i]] = value
+	}
+	return row, nil
+}
+
+// 辅助函数13: 限制参数是否加了行锁(for_update)，加行锁的读取只能在主库执行
+func utilHasLock(ConOpt []map[string]interface{}) bool {
+	if len(ConOpt) < 2 {
+		return false
+	}
+	v, ok := ConOpt[1]["for_update"]
+	if !ok {
+		return false
+	}
+	if b, isBool := v.(bool); isBool {
+		return b
+	}
+	return true
+}
+
+// 辅助函数14: 检查行锁选项，只能是 nowait 或 skip locked(不区分大小写)，返回小写的选项
+func utilLockOption(option string) (string, error) {
+	option = strings.ToLower(strings.Join(strings.Fields(option), " "))
+	switch option {
+	case "", "nowait", "skip locked":
+		return option, nil
+	}
+	return "", errors.New("for update 的选项只能是 nowait 或 skip locked: " + option)
+}
+
+// 辅助函数15: 是否为连接错误(连接断开、连不上、数据库正在关闭或启动)，只读库出现时改用主库
+func utilIsConnError(err error) bool {
+	var netErr net.Error
+	if errors.Is(err, driver.ErrBadConn) || errors.As(err, &netErr) {
+		return true
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case "57P01", "57P02", "57P03": // admin_shutdown、crash_shutdown、cannot_connect_now
+			return true
+		}
+		return pqErr.Code.Class() == "08" // connection_exception
+	}
+	return false
+}
+
+// 辅助函数16: group、order 限制参数转换成sql，字段名加上双引号；order的每项可带 asc/desc、nulls first/last；URaw 原样使用
+func utilOrderItems(name string, v interface{}) (string, []interface{}, error) {
+	var list []string
+	switch inst := v.(type) {
+	case URaw:
+		return inst.Sql, inst.Args, nil
+	case string:
+		list = utilSplitComma(inst)
+	case []string:
+		for _, item := range inst {
+			list = append(list, utilSplitComma(item)...)
+		}
+	default:
+		return "", nil, errors.New(name + " 参数必须是字符串、字符串切片或 URaw")
+	}
+
+	items := make([]string, 0, len(list))
+	for _, item := range list {
+		// 字段：第一个不在双引号里的空白之前
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		i := 0
+		for i < len(item) && item[i] != ' ' && item[i] != '\t' {
+			if item[i] == '"' {
+				i = utilSqlSkipQuoted(item, i, '"', false)
+				continue
+			}
+			i++
+		}
+		// 没加双引号的字段名只能是字母、数字、下划线，避免把表达式当作字段名
+		for _, part := range utilSplitDotted(item[:i]) {
+			for k := 0; k < len(part) && part[0] != '"'; k++ {
+				if !utilSqlIsWordChar(part[k]) && part[k] != '$' {
+					return "", nil, errors.New(name + " 参数格式错误，表达式请使用 URaw: " + item)
+				}
+			}
+		}
+		field, err := utilQuoteColumn(item[:i])
+		if err != nil {
+			return "", nil, err
+		}
+
+		// 排序方向：group 不能带，order 只能是 [asc|desc] [nulls first|last]
+		words := strings.Fields(strings.ToLower(item[i:]))
+		if name == "order" && len(words) > 0 && (words[0] == "asc" || words[0] == "desc") {
+			field += " " + words[0]
+			words = words[1:]
+		}
+		if name == "order" && len(words) == 2 && words[0] == "nulls" && (words[1] == "first" || words[1] == "last") {
+			field += " nulls " + words[1]
+			words = nil
+		}
+		if len(words) > 0 {
+			return "", nil, errors.New(name + " 参数格式错误，表达式请使用 URaw: " + item)
+		}
+		items = append(items, field)
+	}
+	return strings.Join(items, ","), nil, nil
+}
+
+// 辅助函数17: 按逗号拆分，双引号里的逗号不拆分
+func utilSplitComma(s string) []string {
+	parts := make([]string, 0, 1)
+	start := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] == '"' {
+			i = utilSqlSkipQuoted(s, i, '"', false) - 1
+		} else if s[i] == ',' {
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// 辅助函数18: 批量写入每批的行数，每批参数(每行字段数 × 行数 + suffix中的参数)不超过65535个
+func utilInsertBatchSize(fieldNum int, suffixArgNum int) int {
+	return (65535 - suffixArgNum) / fieldNum
+}
+
+// 辅助函数19: 拼凑一批count行的写入语句(不含suffix)，fields为加上双引号的字段名
+// casts为nil时使用 insert into table(字段) values (...),(...)；
+// 否则每行末尾附加序号，使用 insert into table(字段) select ... from (values ...) order by 序号，按rows的顺序写入和返回，
+// casts[i]为fields[i]的类型，values中的参数按该类型转换，为空时不转换
+func utilInsertBatchSql(KeyTable string, fields []string, casts []string, count int) string {
+	KeyPrefix := "insert into " + KeyTable + "(" + strings.Join(fields, ",") + ") "
+	flags := make([]string, len(fields))
+	for i := range fields {
+		flags[i] = "?"
+		if casts != nil && casts[i] != "" {
+			flags[i] = "?::" + casts[i]
+		}
+	}
+	KeyFlag := strings.Join(flags, ",")
+	if casts == nil {
+		rows := make([]string, count)
+		for i := range rows {
+			rows[i] = "(" + KeyFlag + ")"
+		}
+		return KeyPrefix + "values " + strings.Join(rows, ",")
+	}
+
+	// values 的列名使用 c1..cN 和 ord，不会和字段名冲突
+	cols := make([]string, len(fields))
+	for i := range fields {
+		cols[i] = "c" + strconv.Itoa(i+1)
+	}
+	rows := make([]string, count)
+	for i := range rows {
+		rows[i] = "(" + KeyFlag + "," + strconv.Itoa(i+1) + ")"
+	}
+	return KeyPrefix + "select v." + strings.Join(cols, ",v.") + " from (values " + strings.Join(rows, ",") +
+		") as v(" + strings.Join(cols, ",") + ",ord) order by v.ord"
+}
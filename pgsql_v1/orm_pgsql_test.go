@@ -0,0 +1,269 @@
+package pgsql_v1
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUtilTypedValue(t *testing.T) {
+	now := time.Now()
+	cases := []struct {
+		v      interface{}
+		dbType string
+		want   interface{}
+	}{
+		{[]byte("42"), "INT4", int64(42)},
+		{[]byte("-9007199254740993"), "INT8", int64(-9007199254740993)},
+		{[]byte("1.5"), "FLOAT8", 1.5},
+		{[]byte("t"), "BOOL", true},
+		{[]byte("f"), "BOOL", false},
+		{[]byte("12.30"), "NUMERIC", "12.30"}, // numeric保留精度，返回字符串
+		{[]byte("abc"), "TEXT", "abc"},
+		{[]byte{0, 1}, "BYTEA", []byte{0, 1}},
+		{[]byte("x"), "INT4", "x"}, // 解析失败时返回字符串
+		{int64(7), "INT8", int64(7)},
+		{now, "TIMESTAMPTZ", now},
+		{nil, "TEXT", nil},
+	}
+	for _, c := range cases {
+		if got := utilTypedValue(c.v, c.dbType); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("utilTypedValue(%v, %s) = %#v, want %#v", c.v, c.dbType, got, c.want)
+		}
+	}
+}
+
+func TestUtilMakeOptions(t *testing.T) {
+	cases := []struct {
+		options map[string]interface{}
+		want    string
+		args    []interface{}
+		wantErr bool
+	}{
+		{map[string]interface{}{}, "select * from t", []interface{}{1}, false},
+		{map[string]interface{}{"limit": 10, "offset": 20}, "select * from t limit ? offset ?", []interface{}{1, 10, 20}, false},
+		{map[string]interface{}{"group": "class_id", "order": []string{"status", "id desc"}},
+			`select * from t group by "class_id" order by "status","id" desc`, []interface{}{1}, false},
+		{map[string]interface{}{"order": "", "group": []string{}}, "select * from t", []interface{}{1}, false},
+		{map[string]interface{}{"for_update": true}, "select * from t for update", []interface{}{1}, false},
+		{map[string]interface{}{"for_update": false}, "select * from t", []interface{}{1}, false},
+		{map[string]interface{}{"for_update": " Skip   Locked "}, "select * from t for update skip locked", []interface{}{1}, false},
+		{map[string]interface{}{"for_update": ""}, "select * from t for update", []interface{}{1}, false},
+		{map[string]interface{}{"for_update": "nowait; drop table t"}, "", nil, true},
+		{map[string]interface{}{"for_update": 1}, "", nil, true},
+		{map[string]interface{}{"order": 1}, "", nil, true},
+	}
+	for _, c := range cases {
+		got, args, err := utilMakeOptions("select * from t", []interface{}{1}, c.options)
+		if (err != nil) != c.wantErr {
+			t.Errorf("utilMakeOptions(%v) err = %v, wantErr %v", c.options, err, c.wantErr)
+			continue
+		}
+		if err == nil && (got != c.want || !reflect.DeepEqual(args, c.args)) {
+			t.Errorf("utilMakeOptions(%v) = %q %v, want %q %v", c.options, got, args, c.want, c.args)
+		}
+	}
+}
+
+func TestUtilHasLock(t *testing.T) {
+	if utilHasLock(nil) || utilHasLock([]map[string]interface{}{{"for_update": true}}) {
+		t.Error("utilHasLock should only read the options map")
+	}
+	if utilHasLock([]map[string]interface{}{{}, {"for_update": false}}) {
+		t.Error("for_update false should not lock")
+	}
+	if !utilHasLock([]map[string]interface{}{{}, {"for_update": "nowait"}}) {
+		t.Error("for_update nowait should lock")
+	}
+}
+
+func TestUtilOrderItems(t *testing.T) {
+	cases := []struct {
+		name    string
+		v       interface{}
+		want    string
+		args    []interface{}
+		wantErr bool
+	}{
+		{"order", "id", `"id"`, nil, false},
+		{"order", " stars DESC , t.id asc NULLS last", `"stars" desc,"t"."id" asc nulls last`, nil, false},
+		{"order", []string{"a nulls first", `"x,y" desc`}, `"a" nulls first,"x,y" desc`, nil, false},
+		{"order", "", "", nil, false},
+		{"group", "class_id, t.status", `"class_id","t"."status"`, nil, false},
+		{"order", URaw{Sql: "abs(x - ?) desc", Args: []interface{}{5}}, "abs(x - ?) desc", []interface{}{5}, false},
+		{"order", "id; drop table t", "", nil, true},
+		{"order", "(select 1)", "", nil, true},
+		{"order", "count(*) desc", "", nil, true},
+		{"order", "id desc desc", "", nil, true},
+		{"order", "id nulls", "", nil, true},
+		{"group", "id desc", "", nil, true},
+		{"group", 1, "", nil, true},
+	}
+	for _, c := range cases {
+		got, args, err := utilOrderItems(c.name, c.v)
+		if (err != nil) != c.wantErr {
+			t.Errorf("utilOrderItems(%s, %v) err = %v, wantErr %v", c.name, c.v, err, c.wantErr)
+			continue
+		}
+		if err == nil && (got != c.want || !reflect.DeepEqual(args, c.args)) {
+			t.Errorf("utilOrderItems(%s, %v) = %q %v, want %q %v", c.name, c.v, got, args, c.want, c.args)
+		}
+	}
+}
+
+func TestUtilQuoteIdentTable(t *testing.T) {
+	idents := map[string]string{
+		"order":     `"order"`,
+		`"User"`:    `"User"`,
+		`a"b`:       `"a""b"`,
+		`"a""b"`:    `"a""b"`,
+		"中文":        `"中文"`,
+		"x; drop t": `"x; drop t"`,
+	}
+	for in, want := range idents {
+		if got, err := UtilQuoteIdent(in); err != nil || got != want {
+			t.Errorf("UtilQuoteIdent(%q) = %q, %v, want %q", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", `""`, "a\x00b", strings.Repeat("x", 64)} {
+		if got, err := UtilQuoteIdent(in); err == nil {
+			t.Errorf("UtilQuoteIdent(%q) = %q, want error", in, got)
+		}
+	}
+
+	tables := map[string]string{
+		"demo":              `"demo"`,
+		"public.demo":       `"public"."demo"`,
+		`"my.schema"."t.1"`: `"my.schema"."t.1"`,
+	}
+	for in, want := range tables {
+		if got, err := UtilQuoteTable(in); err != nil || got != want {
+			t.Errorf("UtilQuoteTable(%q) = %q, %v, want %q", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"a.b.c", "", "a."} {
+		if got, err := UtilQuoteTable(in); err == nil {
+			t.Errorf("UtilQuoteTable(%q) = %q, want error", in, got)
+		}
+	}
+}
+
+// insertDriver 模拟批量写入：有 order by v.ord 时按序号写入，否则按 values 的倒序写入(postgres不保证顺序)，
+// returning 返回每行 n 字段的值；读取字段类型时返回 n、name 的类型
+type insertDriver struct{ batches []int }
+type insertConn struct{ d *insertDriver }
+type insertStmt struct {
+	d *insertDriver
+	q string
+}
+type insertRows struct {
+	cols []string
+	data [][]driver.Value
+}
+
+func (d *insertDriver) Open(string) (driver.Conn, error) { return insertConn{d}, nil }
+func (c insertConn) Prepare(q string) (driver.Stmt, error) {
+	return insertStmt{c.d, q}, nil
+}
+func (insertConn) Close() error              { return nil }
+func (insertConn) Begin() (driver.Tx, error) { return insertConn{}, nil }
+func (insertConn) Commit() error             { return nil }
+func (insertConn) Rollback() error           { return nil }
+func (insertStmt) Close() error              { return nil }
+func (insertStmt) NumInput() int             { return -1 }
+func (insertStmt) Exec([]driver.Value) (driver.Result, error) {
+	return driver.RowsAffected(0), nil
+}
+func (s insertStmt) Query(args []driver.Value) (driver.Rows, error) {
+	if strings.Contains(s.q, "pg_attribute") {
+		return &insertRows{[]string{"field", "type"}, [][]driver.Value{{"n", "bigint"}, {"name", "text"}}}, nil
+	}
+	s.d.batches = append(s.d.batches, len(args))
+	order := make([]int, len(args)/2)
+	for i := range order {
+		order[len(order)-1-i] = i
+	}
+	if strings.Contains(s.q, "order by v.ord") {
+		ords := regexp.MustCompile(`,(\d+)\)`).FindAllStringSubmatch(s.q, -1)
+		sort.Slice(order, func(i, j int) bool {
+			a, _ := strconv.Atoi(ords[order[i]][1])
+			b, _ := strconv.Atoi(ords[order[j]][1])
+			return a < b
+		})
+	}
+	rows := &insertRows{cols: []string{"id"}}
+	for _, i := range order {
+		rows.data = append(rows.data, []driver.Value{args[i*2]})
+	}
+	return rows, nil
+}
+func (r *insertRows) Columns() []string { return r.cols }
+func (r *insertRows) Close() error      { return nil }
+func (r *insertRows) Next(dest []driver.Value) error {
+	if len(r.data) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.data[0])
+	r.data = r.data[1:]
+	return nil
+}
+
+func TestInsertManyOrder(t *testing.T) {
+	d := &insertDriver{}
+	sql.Register("pgsql_v1_insert", d)
+	db, _ := sql.Open("pgsql_v1_insert", "")
+	defer db.Close()
+	orm := ormPgsql{pool: newDbPool(&poolDbs{primary: db}), dbCfgName: "test"}
+
+	// 2个字段每批32767行，70000行分3批
+	rows := make([]map[string]interface{}, 70000)
+	for i := range rows {
+		rows[i] = map[string]interface{}{"n": i, "name": "row" + strconv.Itoa(i)}
+	}
+	ids, err := orm.InsertManyCtx(context.Background(), "demo", rows, "id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(d.batches, []int{65534, 65534, 8932}) {
+		t.Errorf("batch args = %v, want [65534 65534 8932]", d.batches)
+	}
+	if len(ids) != len(rows) {
+		t.Fatalf("len(ids) = %d, want %d", len(ids), len(rows))
+	}
+	for i, id := range ids {
+		if id != int64(rows[i]["n"].(int)) {
+			t.Fatalf("ids[%d] = %d, want the id of rows[%d]", i, id, i)
+		}
+	}
+}
+
+func TestUtilInsertBatchSql(t *testing.T) {
+	if got := utilInsertBatchSize(2, 0); got != 32767 {
+		t.Errorf("utilInsertBatchSize(2, 0) = %d", got)
+	}
+	if got := utilInsertBatchSize(3, 4); got != 21843 {
+		t.Errorf("utilInsertBatchSize(3, 4) = %d", got)
+	}
+	if got := utilInsertBatchSize(70000, 0); got != 0 {
+		t.Errorf("utilInsertBatchSize(70000, 0) = %d, want 0", got)
+	}
+
+	fields := []string{`"a"`, `"b"`}
+	got := utilInsertBatchSql(`"t"`, fields, nil, 2)
+	if want := `insert into "t"("a","b") values (?,?),(?,?)`; got != want {
+		t.Errorf("utilInsertBatchSql plain = %q, want %q", got, want)
+	}
+	got, err := UtilFormatExecE(utilInsertBatchSql(`"t"`, fields, []string{"integer", ""}, 2))
+	want := `insert into "t"("a","b") select v.c1,v.c2 from (values ($1::integer,$2,1),($3::integer,$4,2)) as v(c1,c2,ord) order by v.ord`
+	if err != nil || got != want {
+		t.Errorf("utilInsertBatchSql ordered = %q, %v, want %q", got, err, want)
+	}
+}
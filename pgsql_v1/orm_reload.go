@@ -0,0 +1,315 @@
+package pgsql_v1
+
+import (
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	reloadLock = sync.Mutex{} // 重新加载配置的锁，同一时间只有一次重新加载；pathConfig 也在该锁下读写
+	drainGrace = time.Minute  // 替换下来的数据库句柄延迟关闭的时间，替换前已取到旧句柄的操作在这段时间内开始执行
+)
+
+// WatchConfig 监听配置文件，文件修改时间变化或收到SIGHUP信号时重新加载配置(见ReloadConfig)，返回停止监听的函数
+// interval为检查文件修改时间的间隔，默认5秒；只能在 Init/InitE 之后调用
+// 示例:
+//
+//	stop, err := pgsql_v1.WatchConfig(10 * time.Second)
+//	defer stop()
+func WatchConfig(interval time.Duration) (func(), error) {
+	reloadLock.Lock()
+	path := pathConfig
+	reloadLock.Unlock()
+	if path == "" {
+		return nil, errors.New("请先使用配置文件初始化(Init/InitE)")
+	}
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	modTime, size := info.ModTime(), info.Size()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP)
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		defer signal.Stop(sigs)
+		for {
+			select {
+			case <-done:
+				return
+			case <-sigs:
+				log.Info("收到SIGHUP信号，重新加载配置文件 " + path)
+			case <-ticker.C:
+				info, err := os.Stat(path)
+				if err != nil {
+					log.Error(err)
+					continue
+				}
+				if info.ModTime().Equal(modTime) && info.Size() == size {
+					continue
+				}
+				modTime, size = info.ModTime(), info.Size()
+				log.Info("配置文件已修改，重新加载 " + path)
+			}
+			_ = ReloadConfig() // 出错时已记录日志，保留原配置
+		}
+	}()
+
+	once := sync.Once{}
+	return func() { once.Do(func() { close(done) }) }, nil
+}
+
+// ReloadConfig 重新读取配置文件，和当前的 pg_ 配置段比较：
+// 1. 只修改了 maxConn、maxIdle、maxLifetime、balance 时直接调整连接池
+// 2. 修改了连接参数(host、password、sslmode、replicas等)时用新配置创建并检测连接，成功后替换，旧的连接池等待连接归还后关闭；检测失败时保留原配置
+// 3. 修改了 scanMode 时之后获取的句柄生效
+// 4. 新增的配置段初始化连接，删除的配置段关闭连接
+// 说明：只处理配置文件中的配置段，InitFromDSN/InitFromEnv/InitFromConfig 的配置不受影响(同名配置段被忽略)；配置文件有错时不做任何修改
+func ReloadConfig() error {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+
+	// 1、读取配置文件
+	if pathConfig == "" {
+		err := errors.New("请先使用配置文件初始化(Init/InitE)")
+		log.Error(err)
+		return err
+	}
+	configs, err := readConfigFile(pathConfig)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	olds := map[string]Config{}
+	others := map[string]bool{} // InitFromDSN/InitFromEnv/InitFromConfig 设置的配置段
+	handleLock.RLock()
+	for name := range fileSect {
+		olds[name] = dbSections[name]
+	}
+	for name := range dbSections {
+		others[name] = !fileSect[name]
+	}
+	handleLock.RUnlock()
+
+	// 2、删除的配置段
+	for name := range olds {
+		if _, ok := configs[name]; !ok {
+			removeSection(name)
+		}
+	}
+
+	// 3、新增和修改的配置段，出错时继续处理其他配置段，返回第一个错误
+	var firstErr error
+	for name, c := range configs {
+		if others[name] {
+			log.Warn("数据库配置 " + name + " 已通过 InitFromConfig 等设置，忽略配置文件中的同名配置段")
+			continue
+		}
+		old, ok := olds[name]
+		if !ok {
+			err = addSection(name, c)
+		} else {
+			err = reloadSection(name, old, c)
+		}
+		if err != nil {
+			log.Error(err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// 新增配置段
+func addSection(name string, c Config) error {
+	handleLock.Lock()
+	dbSections[name] = c
+	fileSect[name] = true
+	handleLock.Unlock()
+	log.Info("数据库配置 " + name + " 已新增")
+	return initConnect(name, c, true)
+}
+
+// 删除配置段：之后无法再获取该配置的句柄，已有的连接等待归还后关闭
+func removeSection(name string) {
+	handleLock.Lock()
+	delete(dbSections, name)
+	delete(fileSect, name)
+	list := sectionHandles(name)
+	for _, h := range list {
+		delete(handles, h.dbInstance)
+	}
+	handleLock.Unlock()
+	for _, h := range list {
+		go drainDb(h.dbInstance, h.pool.load())
+	}
+	log.Info("数据库配置 " + name + " 已删除")
+}
+
+// 修改配置段
+func reloadSection(name string, old Config, c Config) error {
+	changes := utilConfigChanges(old, c)
+	if len(changes) == 0 {
+		return nil
+	}
+
+	// 1、连接参数变化时，先用新配置创建并检测全部连接，有一个失败则保留原配置
+	// 创建连接时不加锁，期间可能有新的句柄，加锁后重新获取句柄列表，有未创建连接的句柄时再创建一次
+	newDbs := map[string]*poolDbs{}
+	closeNew := func() {
+		for _, v := range newDbs {
+			v.close()
+		}
+	}
+	var list []*ormPgsql
+	for {
+		handleLock.RLock()
+		list = sectionHandles(name)
+		handleLock.RUnlock()
+		if old.connKey() != c.connKey() {
+			for _, h := range list {
+				if _, ok := newDbs[h.dbInstance]; ok {
+					continue
+				}
+				db := c.Db
+				if h.dbName != "" {
+					db = h.dbName
+				}
+				dbs, err := openDb(c, db)
+				if err == nil {
+					if err = pingDb(dbs, c); err != nil {
+						dbs.close()
+					}
+				}
+				if err != nil {
+					closeNew()
+					return &UInitError{Name: name, Err: errors.New("新配置连接失败，保留原配置: " + err.Error())}
+				}
+				newDbs[h.dbInstance] = dbs
+			}
+		}
+
+		// 加锁后句柄列表没有变化时继续替换(保持加锁)
+		handleLock.Lock()
+		list = sectionHandles(name)
+		missing := false
+		if old.connKey() != c.connKey() {
+			for _, h := range list {
+				if _, ok := newDbs[h.dbInstance]; !ok {
+					missing = true
+				}
+			}
+		}
+		if !missing {
+			break
+		}
+		handleLock.Unlock()
+	}
+
+	// 2、替换连接或调整连接池和负载均衡方式，scanMode 变化时替换句柄；之后创建的句柄使用新配置
+	dbSections[name] = c
+	for _, h := range list {
+		if dbs, ok := newDbs[h.dbInstance]; ok {
+			delete(newDbs, h.dbInstance)
+			go drainDb(h.dbInstance, h.pool.swap(dbs))
+		} else {
+			dbs := *h.pool.load()
+			dbs.balance = c.Balance
+			setPoolLimits(&dbs, c)
+			h.pool.swap(&dbs)
+		}
+		if h.scanMode != c.ScanMode {
+			newHandle := *h
+			newHandle.scanMode = c.ScanMode
+			handles[h.dbInstance] = &newHandle
+		}
+	}
+	handleLock.Unlock()
+	closeNew() // 剩下的是创建连接后被删除的句柄
+	log.Info("数据库配置 " + name + " 已修改: " + strings.Join(changes, ", "))
+	return nil
+}
+
+// 某个配置名称的全部句柄，调用前需加锁
+func sectionHandles(name string) []*ormPgsql {
+	list := make([]*ormPgsql, 0)
+	for _, h := range handles {
+		if h.dbCfgName == name {
+			list = append(list, h)
+		}
+	}
+	return list
+}
+
+// 延迟 drainGrace 后关闭主库和只读库的句柄；Close 不再接受新的操作，并等待已开始的操作结束、连接归还后返回
+func drainDb(dbInstance string, dbs *poolDbs) {
+	time.Sleep(drainGrace)
+	for _, dbHandle := range dbs.all() {
+		if err := dbHandle.Close(); err != nil {
+			log.Error(err)
+		}
+	}
+	log.Info("旧的数据库连接已关闭 " + dbInstance)
+}
+
+// 辅助函数: 比较两个配置，返回修改的配置项说明，密码和证书等不显示值
+func utilConfigChanges(old Config, c Config) []string {
+	changes := make([]string, 0)
+	diff := func(key string, a, b string, secret bool) {
+		if a == b {
+			return
+		}
+		if secret {
+			changes = append(changes, key+" 已修改")
+		} else {
+			changes = append(changes, key+": "+a+" => "+b)
+		}
+	}
+	diff("host", old.Host, c.Host, false)
+	diff("port", old.Port, c.Port, false)
+	diff("db", old.Db, c.Db, false)
+	diff("username", old.Username, c.Username, false)
+	diff("password", old.Password, c.Password, true)
+	diff("charset", old.Charset, c.Charset, false)
+	diff("maxIdle", strconv.Itoa(old.MaxIdle), strconv.Itoa(c.MaxIdle), false)
+	diff("maxConn", strconv.Itoa(old.MaxConn), strconv.Itoa(c.MaxConn), false)
+	diff("maxLifetime", strconv.Itoa(old.MaxLifetime), strconv.Itoa(c.MaxLifetime), false)
+	diff("scanMode", old.ScanMode, c.ScanMode, false)
+	diff("sslmode", old.SslMode, c.SslMode, false)
+	diff("sslrootcert", old.SslRootCert, c.SslRootCert, false)
+	diff("sslcert", old.SslCert, c.SslCert, false)
+	diff("sslkey", old.SslKey, c.SslKey, true)
+	diff("connect_timeout", strconv.Itoa(old.ConnectTimeout), strconv.Itoa(c.ConnectTimeout), false)
+	diff("application_name", old.ApplicationName, c.ApplicationName, false)
+	diff("search_path", old.SearchPath, c.SearchPath, false)
+	diff("timezone", old.Timezone, c.Timezone, false)
+	diff("lazy", strconv.FormatBool(old.Lazy), strconv.FormatBool(c.Lazy), false)
+	diff("optional", strconv.FormatBool(old.Optional), strconv.FormatBool(c.Optional), false)
+	diff("replicas", strings.Join(old.Replicas, ","), strings.Join(c.Replicas, ","), false)
+	diff("balance", old.Balance, c.Balance, false)
+	for key, v := range c.Options {
+		if ov, ok := old.Options[key]; !ok || ov != v {
+			diff(configOptionPrefix+key, ov, v, false)
+		}
+	}
+	for key, v := range old.Options {
+		if _, ok := c.Options[key]; !ok {
+			changes = append(changes, configOptionPrefix+key+": "+v+" => (删除)")
+		}
+	}
+	return changes
+}
@@ -0,0 +1,58 @@
+package pgsql_v1
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUtilConfigChanges(t *testing.T) {
+	base := Config{Host: "h", Port: "5432", Db: "d", Username: "u", Password: "p", MaxConn: 20,
+		Options: map[string]string{"statement_timeout": "5000"}}
+	cases := []struct {
+		modify func(c *Config)
+		want   []string
+	}{
+		{func(c *Config) {}, []string{}},
+		{func(c *Config) { c.Host, c.MaxConn = "h2", 50 }, []string{"host: h => h2", "maxConn: 20 => 50"}},
+		{func(c *Config) { c.Password, c.SslKey = "p2", "/key.pem" }, []string{"password 已修改", "sslkey 已修改"}},
+		{func(c *Config) { c.Db = "d2" }, []string{"db: d => d2"}},
+		{func(c *Config) { c.Replicas, c.Balance = []string{"r1", "r2"}, BalanceLeastConn },
+			[]string{"replicas:  => r1,r2", "balance:  => least_conn"}},
+		{func(c *Config) { c.Lazy = true }, []string{"lazy: false => true"}},
+		{func(c *Config) { c.Options = map[string]string{"statement_timeout": "1000"} }, []string{"opt_statement_timeout: 5000 => 1000"}},
+		{func(c *Config) { c.Options = map[string]string{"lock_timeout": "1s"} },
+			[]string{"opt_lock_timeout:  => 1s", "opt_statement_timeout: 5000 => (删除)"}},
+	}
+	for i, c := range cases {
+		next := base
+		next.Options = map[string]string{}
+		for k, v := range base.Options {
+			next.Options[k] = v
+		}
+		c.modify(&next)
+		if got := utilConfigChanges(base, next); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("case %d: utilConfigChanges = %q, want %q", i, got, c.want)
+		}
+	}
+}
+
+func TestConfigConnKey(t *testing.T) {
+	base := Config{Host: "h", Db: "d", Username: "u", MaxConn: 20}
+	cases := []struct {
+		modify func(c *Config)
+		same   bool
+	}{
+		{func(c *Config) { c.MaxConn, c.Balance, c.ScanMode = 50, BalanceLeastConn, ScanTyped }, true},
+		{func(c *Config) { c.Db = "d2" }, false},
+		{func(c *Config) { c.Password = "p" }, false},
+		{func(c *Config) { c.Replicas = []string{"r1"} }, false},
+		{func(c *Config) { c.Options = map[string]string{"statement_timeout": "1"} }, false},
+	}
+	for i, c := range cases {
+		next := base
+		c.modify(&next)
+		if got := base.connKey() == next.connKey(); got != c.same {
+			t.Errorf("case %d: same connKey = %v, want %v", i, got, c.same)
+		}
+	}
+}
@@ -0,0 +1,474 @@
+package pgsql_v1
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"strconv"
+	"strings"
+)
+
+// USelect 结构体10：select查询构造器，通过 Handle().Select(...) 创建
+// 示例:
+//
+//	rows, err := Handle().Select("d.id", "d.debug", "u.name").
+//	    From("demo d").
+//	    LeftJoin("users u", "u.id = d.creator_id").
+//	    Where(map[string]interface{}{"d.status": 1}).
+//	    Where("d.stars > ?", 1.5).
+//	    OrderBy("d.id desc").
+//	    Limit(10).Offset(20).
+//	    All()
+//
+// 说明：字段、排序、分组等表达式原样使用；From、Join 的表名加上双引号，可带别名；
+// 条件字符串中的参数用?，参数为 *USelect 时作为子查询
+type USelect struct {
+	orm       ormPgsql
+	with      []selectPart // 公共表表达式 with name as (...)
+	fields    []string     // 读取的字段
+	from      selectPart   // 数据表或子查询
+	joins     []selectPart // 关联
+	wheres    []selectPart // 条件，用and连接
+	groups    []string     // 分组
+	havings   []selectPart // 分组条件，用and连接
+	orders    []string     // 排序
+	limit     *int64       // 读取条数
+	offset    *int64       // 跳过条数
+	forUpdate string       // 行锁
+	err       error        // 构造过程中的第一个错误，执行时返回
+}
+
+// 语句片段：sql中的参数用?
+type selectPart struct {
+	sql  string
+	args []interface{}
+}
+
+// Select 查询构造1：创建select查询构造器，fields为读取的字段，不传时为"*"
+func (Me ormPgsql) Select(fields ...string) *USelect {
+	if len(fields) == 0 {
+		fields = []string{"*"}
+	}
+	return &USelect{orm: Me, fields: fields}
+}
+
+// With 添加公共表表达式 with name as (sub)
+func (Me *USelect) With(name string, sub *USelect) *USelect {
+	quoted, err := UtilQuoteIdent(name)
+	if err != nil {
+		return Me.setErr(err)
+	}
+	subSql, subArgs, err := sub.build()
+	if err != nil {
+		return Me.setErr(err)
+	}
+	Me.with = append(Me.with, selectPart{sql: quoted + " as (" + subSql + ")", args: subArgs})
+	return Me
+}
+
+// From 设置数据表，可带别名，如 "demo" / "public.demo d"
+func (Me *USelect) From(table string) *USelect {
+	quoted, err := utilQuoteTableAlias(table)
+	if err != nil {
+		return Me.setErr(err)
+	}
+	Me.from = selectPart{sql: quoted}
+	return Me
+}
+
+// FromSub 设置子查询作为数据表 (sub) as alias
+func (Me *USelect) FromSub(sub *USelect, alias string) *USelect {
+	quoted, err := UtilQuoteIdent(alias)
+	if err != nil {
+		return Me.setErr(err)
+	}
+	subSql, subArgs, err := sub.build()
+	if err != nil {
+		return Me.setErr(err)
+	}
+	Me.from = selectPart{sql: "(" + subSql + ") as " + quoted, args: subArgs}
+	return Me
+}
+
+// Join 内关联 join table on ...，on中的参数用?
+func (Me *USelect) Join(table string, on string, args ...interface{}) *USelect {
+	return Me.join("join", table, on, args)
+}
+
+// LeftJoin 左关联 left join table on ...，on中的参数用?
+func (Me *USelect) LeftJoin(table string, on string, args ...interface{}) *USelect {
+	return Me.join("left join", table, on, args)
+}
+
+// RightJoin 右关联 right join table on ...，on中的参数用?
+func (Me *USelect) RightJoin(table string, on string, args ...interface{}) *USelect {
+	return Me.join("right join", table, on, args)
+}
+
+// 添加关联
+func (Me *USelect) join(kind string, table string, on string, args []interface{}) *USelect {
+	quoted, err := utilQuoteTableAlias(table)
+	if err != nil {
+		return Me.setErr(err)
+	}
+	onSql, onArgs, err := utilExpandSubSelect(on, args)
+	if err != nil {
+		return Me.setErr(err)
+	}
+	Me.joins = append(Me.joins, selectPart{sql: kind + " " + quoted + " on " + onSql, args: onArgs})
+	return Me
+}
+
+// Where 添加条件，多次调用用and连接
+// cond 为字符串时参数用?，参数为 *USelect 时作为子查询；为map时格式同 QueryTable 的条件参数
+func (Me *USelect) Where(cond interface{}, args ...interface{}) *USelect {
+	part, err := utilSelectCondition(cond, args)
+	if err != nil {
+		return Me.setErr(err)
+	}
+	Me.wheres = append(Me.wheres, part)
+	return Me
+}
+
+// GroupBy 添加分组字段
+func (Me *USelect) GroupBy(fields ...string) *USelect {
+	Me.groups = append(Me.groups, fields...)
+	return Me
+}
+
+// Having 添加分组条件，多次调用用and连接，参数同Where
+func (Me *USelect) Having(cond interface{}, args ...interface{}) *USelect {
+	part, err := utilSelectCondition(cond, args)
+	if err != nil {
+		return Me.setErr(err)
+	}
+	Me.havings = append(Me.havings, part)
+	return Me
+}
+
+// OrderBy 添加排序，如 "id desc"
+func (Me *USelect) OrderBy(fields ...string) *USelect {
+	Me.orders = append(Me.orders, fields...)
+	return Me
+}
+
+// Limit 设置读取条数
+func (Me *USelect) Limit(n int64) *USelect {
+	Me.limit = &n
+	return Me
+}
+
+// Offset 设置跳过条数
+func (Me *USelect) Offset(n int64) *USelect {
+	Me.offset = &n
+	return Me
+}
+
+// ForUpdate 加行锁，option追加在 for update 之后，只能是 "nowait" / "skip locked"
+func (Me *USelect) ForUpdate(option ...string) *USelect {
+	lockOption, err := utilLockOption(strings.Join(option, " "))
+	if err != nil {
+		return Me.setErr(err)
+	}
+	Me.forUpdate = strings.TrimSpace("for update " + lockOption)
+	return Me
+}
+
+// ToSQL 获取最终执行的sql和参数，参数占位为$n，调试用
+func (Me *USelect) ToSQL() (string, []interface{}, error) {
+	KeySql, KeyArgs, err := Me.build()
+	if err != nil {
+		return "", nil, err
+	}
+	if KeySql, err = UtilFormatExecE(KeySql); err != nil {
+		return "", nil, err
+	}
+	return KeySql, KeyArgs, nil
+}
+
+// All 执行查询，返回全部数据
+func (Me *USelect) All() ([]map[string]interface{}, error) {
+	return Me.AllCtx(context.Background())
+}
+
+// AllCtx 执行查询，返回全部数据，可通过ctx取消或设置超时
+func (Me *USelect) AllCtx(ctx context.Context) ([]map[string]interface{}, error) {
+	KeySql, KeyArgs, err := Me.build()
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	if Me.orm.initErr {
+		log.Error("数据库未连接成功", Me.orm.dbCfgName, Me.orm.dbName)
+		return nil, errors.New("数据库未连接成功:" + Me.orm.dbCfgName + " . " + Me.orm.dbName)
+	}
+
+	rows, err := Me.reader(KeySql).queryMaps(ctx, KeySql, KeyArgs)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	return rows, nil
+}
+
+// One 执行查询，返回第一条数据，没有设置Limit时自动加上 limit 1；未找到返回nil
+func (Me *USelect) One() (map[string]interface{}, error) {
+	return Me.OneCtx(context.Background())
+}
+
+// OneCtx 执行查询，返回第一条数据，可通过ctx取消或设置超时
+func (Me *USelect) OneCtx(ctx context.Context) (map[string]interface{}, error) {
+	// 在副本上加 limit 1，不影响构造器之后的查询
+	sel := *Me
+	if sel.limit == nil {
+		sel.Limit(1)
+	}
+	rows, err := sel.AllCtx(ctx)
+	if err != nil || len(rows) == 0 {
+		return nil, err
+	}
+	return rows[0], nil
+}
+
+// Into 执行查询，结果写入dest：结构体切片指针写入全部数据，结构体指针写入第一条(未找到返回 sql.ErrNoRows)
+// 字段对应规则同 QueryInto
+func (Me *USelect) Into(dest interface{}) error {
+	return Me.IntoCtx(context.Background(), dest)
+}
+
+// IntoCtx 执行查询，结果写入dest，可通过ctx取消或设置超时
+func (Me *USelect) IntoCtx(ctx context.Context, dest interface{}) error {
+	KeySql, KeyArgs, err := Me.build()
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	if Me.orm.initErr {
+		log.Error("数据库未连接成功", Me.orm.dbCfgName, Me.orm.dbName)
+		return errors.New("数据库未连接成功:" + Me.orm.dbCfgName + " . " + Me.orm.dbName)
+	}
+	return Me.reader(KeySql).queryInto(ctx, dest, KeySql, KeyArgs)
+}
+
+// Iter 执行查询，返回逐行读取的结果集，用法同 QueryIter
+func (Me *USelect) Iter() (*URows, error) {
+	return Me.IterCtx(context.Background())
+}
+
+// IterCtx 执行查询，返回逐行读取的结果集，可通过ctx取消或设置超时
+func (Me *USelect) IterCtx(ctx context.Context) (*URows, error) {
+	KeySql, KeyArgs, err := Me.build()
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	if Me.orm.initErr {
+		log.Error("数据库未连接成功", Me.orm.dbCfgName, Me.orm.dbName)
+		return nil, errors.New("数据库未连接成功:" + Me.orm.dbCfgName + " . " + Me.orm.dbName)
+	}
+	return Me.reader(KeySql).queryIter(ctx, KeySql, KeyArgs)
+}
+
+// Value 执行查询，第一条数据的第一个字段写入dest，如 count(*)；未找到返回 sql.ErrNoRows
+// 示例: var num int64; err := Handle().Select("count(*)").From("demo").Value(&num)
+func (Me *USelect) Value(dest interface{}) error {
+	return Me.ValueCtx(context.Background(), dest)
+}
+
+// ValueCtx 执行查询，第一条数据的第一个字段写入dest，可通过ctx取消或设置超时
+func (Me *USelect) ValueCtx(ctx context.Context, dest interface{}) error {
+	KeySql, KeyArgs, err := Me.prepare()
+	if err != nil {
+		return err
+	}
+	List, err := Me.reader(KeySql).query(ctx, KeySql, KeyArgs)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	defer List.Close()
+	if !List.Next() {
+		if err := List.Err(); err != nil {
+			log.Error(err)
+			return err
+		}
+		return sql.ErrNoRows
+	}
+	if err := List.Scan(dest); err != nil {
+		log.Error(err)
+		return err
+	}
+	return List.Close()
+}
+
+// 读取使用的句柄：加了行锁或不是只读的语句使用主库，否则可以使用只读库
+func (Me *USelect) reader(KeySql string) ormPgsql {
+	return Me.orm.reader(Me.forUpdate != "" || !utilSqlReadOnly(KeySql))
+}
+
+// 执行前检查，返回最终执行的sql和参数
+func (Me *USelect) prepare() (string, []interface{}, error) {
+	if Me.orm.initErr {
+		log.Error("数据库未连接成功", Me.orm.dbCfgName, Me.orm.dbName)
+		return "", nil, errors.New("数据库未连接成功:" + Me.orm.dbCfgName + " . " + Me.orm.dbName)
+	}
+	KeySql, KeyArgs, err := Me.ToSQL()
+	if err != nil {
+		log.Error(err)
+		return "", nil, err
+	}
+	return KeySql, KeyArgs, nil
+}
+
+// 记录第一个错误
+func (Me *USelect) setErr(err error) *USelect {
+	if Me.err == nil {
+		Me.err = err
+	}
+	return Me
+}
+
+// 拼凑sql，参数占位为?
+func (Me *USelect) build() (string, []interface{}, error) {
+	if Me.err != nil {
+		return "", nil, Me.err
+	}
+	if Me.from.sql == "" {
+		return "", nil, errors.New("没有设置数据表")
+	}
+
+	var (
+		KeySql  = strings.Builder{}
+		KeyArgs = make([]interface{}, 0)
+	)
+	// 拼凑多个片段
+	writeParts := func(prefix string, parts []selectPart, sep string) {
+		if len(parts) == 0 {
+			return
+		}
+		KeySql.WriteString(prefix)
+		for i, p := range parts {
+			if i > 0 {
+				KeySql.WriteString(sep)
+			}
+			KeySql.WriteString(p.sql)
+			KeyArgs = append(KeyArgs, p.args...)
+		}
+	}
+
+	// 1、with
+	writeParts("with ", Me.with, ", ")
+	if len(Me.with) > 0 {
+		KeySql.WriteString(" ")
+	}
+
+	// 2、字段、数据表、关联
+	KeySql.WriteString("select " + strings.Join(Me.fields, ", ") + " from ")
+	writeParts("", []selectPart{Me.from}, "")
+	writeParts(" ", Me.joins, " ")
+
+	// 3、条件、分组、排序
+	writeParts(" where ", Me.wheres, " and ")
+	if len(Me.groups) > 0 {
+		KeySql.WriteString(" group by " + strings.Join(Me.groups, ", "))
+	}
+	writeParts(" having ", Me.havings, " and ")
+	if len(Me.orders) > 0 {
+		KeySql.WriteString(" order by " + strings.Join(Me.orders, ", "))
+	}
+
+	// 4、limit、offset、行锁
+	if Me.limit != nil {
+		KeySql.WriteString(" limit " + strconv.FormatInt(*Me.limit, 10))
+	}
+	if Me.offset != nil {
+		KeySql.WriteString(" offset " + strconv.FormatInt(*Me.offset, 10))
+	}
+	if Me.forUpdate != "" {
+		KeySql.WriteString(" " + Me.forUpdate)
+	}
+
+	return KeySql.String(), KeyArgs, nil
+}
+
+// 辅助函数: Where、Having的条件转换成语句片段
+func utilSelectCondition(cond interface{}, args []interface{}) (selectPart, error) {
+	switch inst := cond.(type) {
+	case string:
+		KeySql, KeyArgs, err := utilExpandSubSelect(inst, args)
+		if err != nil {
+			return selectPart{}, err
+		}
+		return selectPart{sql: "(" + KeySql + ")", args: KeyArgs}, nil
+	case map[string]interface{}:
+		KeySql, KeyArgs, err := utilBuildWhere(inst)
+		if err != nil {
+			return selectPart{}, err
+		}
+		return selectPart{sql: "(" + KeySql + ")", args: KeyArgs}, nil
+	}
+	return selectPart{}, fmt.Errorf("条件必须是字符串或map，实际为: %T", cond)
+}
+
+// 辅助函数: 语句中?对应的参数为 *USelect 时替换成子查询，检查参数个数
+func utilExpandSubSelect(sqlStr string, args []interface{}) (string, []interface{}, error) {
+	var (
+		KeySql  = strings.Builder{}
+		KeyArgs = make([]interface{}, 0, len(args))
+		i       = 0
+	)
+	tokens := utilSqlTokens(sqlStr)
+	if err := utilSqlCheckMixed(sqlStr, tokens); err != nil {
+		return "", nil, err
+	}
+	for _, t := range tokens {
+		if t.kind != sqlTokPlaceholder {
+			KeySql.WriteString(t.text)
+			continue
+		}
+		if i >= len(args) {
+			return "", nil, errors.New("参数个数少于占位符个数: " + sqlStr)
+		}
+		if sub, ok := args[i].(*USelect); ok {
+			subSql, subArgs, err := sub.build()
+			if err != nil {
+				return "", nil, err
+			}
+			KeySql.WriteString("(" + subSql + ")")
+			KeyArgs = append(KeyArgs, subArgs...)
+		} else {
+			KeySql.WriteString("?")
+			KeyArgs = append(KeyArgs, args[i])
+		}
+		i++
+	}
+	if i != len(args) {
+		return "", nil, errors.New("参数个数多于占位符个数: " + sqlStr)
+	}
+	return KeySql.String(), KeyArgs, nil
+}
+
+// 辅助函数: 表名加上双引号，可带别名，如 "public.demo d" => "public"."demo" "d"
+func utilQuoteTableAlias(table string) (string, error) {
+	parts := strings.Fields(table)
+	if len(parts) == 3 && strings.ToLower(parts[1]) == "as" {
+		parts = []string{parts[0], parts[2]}
+	}
+	if len(parts) == 0 || len(parts) > 2 {
+		return "", errors.New("非法的表名: " + table)
+	}
+	quoted, err := UtilQuoteTable(parts[0])
+	if err != nil {
+		return "", err
+	}
+	if len(parts) == 2 {
+		alias, err := UtilQuoteIdent(parts[1])
+		if err != nil {
+			return "", err
+		}
+		quoted += " " + alias
+	}
+	return quoted, nil
+}
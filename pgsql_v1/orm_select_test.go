@@ -0,0 +1,54 @@
+package pgsql_v1
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUSelectToSQL(t *testing.T) {
+	orm := ormPgsql{}
+	cases := []struct {
+		sel     *USelect
+		want    string
+		args    []interface{}
+		wantErr bool
+	}{
+		{orm.Select().From("demo"), `select * from "demo"`, []interface{}{}, false},
+		{orm.Select("d.id", "u.name").From("public.demo d").LeftJoin("users u", "u.id = d.creator_id and u.status = ?", 1).
+			Where(map[string]interface{}{"d.status": 1}).Where("d.stars > ?", 1.5).OrderBy("d.id desc").Limit(10).Offset(20),
+			`select d.id, u.name from "public"."demo" "d" left join "users" "u" on u.id = d.creator_id and u.status = $1 where ("d"."status" = $2) and (d.stars > $3) order by d.id desc limit 10 offset 20`,
+			[]interface{}{1, 1, 1.5}, false},
+		{orm.Select("status", "count(*)").From("demo").GroupBy("status").Having("count(*) > ?", 2),
+			`select status, count(*) from "demo" group by status having (count(*) > $1)`, []interface{}{2}, false},
+		{orm.Select().From("demo").Where("id in ?", orm.Select("id").From("t").Where(map[string]interface{}{"a": 1})).Where("b = ?", 2),
+			`select * from "demo" where (id in (select id from "t" where ("a" = $1))) and (b = $2)`, []interface{}{1, 2}, false},
+		{orm.Select().With("x", orm.Select("id").From("t")).FromSub(orm.Select("id").From("x"), "s"),
+			`with "x" as (select id from "t") select * from (select id from "x") as "s"`, []interface{}{}, false},
+		{orm.Select().From("demo").ForUpdate(), `select * from "demo" for update`, []interface{}{}, false},
+		{orm.Select().From("demo").ForUpdate("SKIP  LOCKED"), `select * from "demo" for update skip locked`, []interface{}{}, false},
+		{orm.Select().From("demo").ForUpdate("; drop table demo"), "", nil, true},
+		{orm.Select().From("demo").Where("a = ? and b = ?", 1), "", nil, true},
+		{orm.Select().From("demo").Where("a = ?", 1, 2), "", nil, true},
+		{orm.Select().From("demo").Where("a = $1 and b = ?", 1), "", nil, true},
+		{orm.Select().From("demo").Where(1), "", nil, true},
+		{orm.Select(), "", nil, true},
+	}
+	for i, c := range cases {
+		got, args, err := c.sel.ToSQL()
+		if (err != nil) != c.wantErr {
+			t.Errorf("case %d: err = %v, wantErr %v", i, err, c.wantErr)
+			continue
+		}
+		if err == nil && (got != c.want || !reflect.DeepEqual(args, c.args)) {
+			t.Errorf("case %d: ToSQL() = %q %v, want %q %v", i, got, args, c.want, c.args)
+		}
+	}
+}
+
+func TestUSelectOneKeepsLimit(t *testing.T) {
+	sel := ormPgsql{initErr: true}.Select().From("demo")
+	_, _ = sel.One()
+	if sel.limit != nil {
+		t.Errorf("One() changed the builder limit to %d", *sel.limit)
+	}
+}
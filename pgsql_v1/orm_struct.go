@@ -0,0 +1,257 @@
+package pgsql_v1
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// 结构体字段映射缓存：reflect.Type => map[列名]字段索引路径
+var structFieldsCache sync.Map
+
+// QueryInto 结构体读取1： 常规读取(格式化sql)，结果写入结构体切片
+// 示例:
+//
+//	var users []User
+//	err := QueryInto(&users, "select * from user where class_id=:class_id" ,
+//	    map[string]interface{}{ "class_id":class_id } ,
+//	)
+//
+// 说明：字段通过 db:"列名" 标签对应数据列，没有标签时使用小写的字段名，db:"-" 和未导出的字段忽略；
+// 结果中没有对应字段的列被忽略，没有对应列的字段保持零值；类型无法转换时返回带列名的错误
+func (Me ormPgsql) QueryInto(dest interface{}, sql string, ConOpt ...map[string]interface{}) error {
+	return Me.QueryIntoCtx(context.Background(), dest, sql, ConOpt...)
+}
+
+// QueryIntoCtx 结构体读取1： 常规读取(格式化sql)，结果写入结构体切片，可通过ctx取消或设置超时
+func (Me ormPgsql) QueryIntoCtx(ctx context.Context, dest interface{}, sql string, ConOpt ...map[string]interface{}) error {
+	if Me.initErr {
+		log.Error("数据库未连接成功", Me.dbCfgName, Me.dbName)
+		return errors.New("数据库未连接成功:" + Me.dbCfgName + " . " + Me.dbName)
+	}
+
+	KeySql, KeyArgs, err := utilQuerySql(sql, ConOpt)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	return Me.reader(utilHasLock(ConOpt) || !utilSqlReadOnly(KeySql)).queryInto(ctx, dest, KeySql, KeyArgs)
+}
+
+// QueryTableInto 结构体读取2： 指定数据表读取一批数据，结果写入结构体切片
+// 示例:
+//
+//	var users []User
+//	err := QueryTableInto(&users, "user",
+//	    map[string]interface{}{ "class_id":class_id } ,
+//	    map[string]interface{}{ "limit":10 } ,
+//	)
+func (Me ormPgsql) QueryTableInto(dest interface{}, table string, ConOpt ...map[string]interface{}) error {
+	return Me.QueryTableIntoCtx(context.Background(), dest, table, ConOpt...)
+}
+
+// QueryTableIntoCtx 结构体读取2： 指定数据表读取一批数据，结果写入结构体切片，可通过ctx取消或设置超时
+func (Me ormPgsql) QueryTableIntoCtx(ctx context.Context, dest interface{}, table string, ConOpt ...map[string]interface{}) error {
+	if Me.initErr {
+		log.Error("数据库未连接成功", Me.dbCfgName, Me.dbName)
+		return errors.New("数据库未连接成功:" + Me.dbCfgName + " . " + Me.dbName)
+	}
+
+	qSql, qArgs, err := utilQueryTableSql(table, "*", ConOpt)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	return Me.reader(utilHasLock(ConOpt) || !utilSqlReadOnly(qSql)).queryInto(ctx, dest, qSql, qArgs)
+}
+
+// QueryTableOneInto 结构体读取3： 指定数据表读取一条数据，结果写入结构体
+// 示例:
+//
+//	var user User
+//	err := QueryTableOneInto(&user, "user", map[string]interface{}{ "user_id":123 })
+//
+// 说明：未找到时返回 sql.ErrNoRows
+func (Me ormPgsql) QueryTableOneInto(dest interface{}, table string, Condition ...map[string]interface{}) error {
+	return Me.QueryTableOneIntoCtx(context.Background(), dest, table, Condition...)
+}
+
+// QueryTableOneIntoCtx 结构体读取3： 指定数据表读取一条数据，结果写入结构体，可通过ctx取消或设置超时
+func (Me ormPgsql) QueryTableOneIntoCtx(ctx context.Context, dest interface{}, table string, Condition ...map[string]interface{}) error {
+	if Me.initErr {
+		log.Error("数据库未连接成功", Me.dbCfgName, Me.dbName)
+		return errors.New("数据库未连接成功:" + Me.dbCfgName + " . " + Me.dbName)
+	}
+
+	// 1、检索条件，读取Condition第一个值作为检索条件
+	KeyConditions := map[string]interface{}{}
+	if len(Condition) > 1 {
+		return errors.New("只需要2个参数")
+	} else if len(Condition) == 1 {
+		KeyConditions = Condition[0]
+	}
+
+	// 2、查询数据
+	qSql, qArgs, err := utilQueryTableSql(table, "*", []map[string]interface{}{KeyConditions, {"limit": 1}})
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	return Me.reader(!utilSqlReadOnly(qSql)).queryInto(ctx, dest, qSql, qArgs)
+}
+
+// 执行查询并把结果写入dest
+func (Me ormPgsql) queryInto(ctx context.Context, dest interface{}, qSql string, qArgs []interface{}) error {
+	if dest == nil {
+		err := errors.New("dest必须是非nil指针")
+		log.Error(err)
+		return err
+	}
+	qSql, err := UtilFormatExecE(qSql)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	List, err := Me.query(ctx, qSql, qArgs)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	err = utilScanInto(List, dest)
+	_ = List.Close()
+	if err != nil && err != sql.ErrNoRows {
+		log.Error(err)
+	}
+	return err
+}
+
+// 辅助函数: 查询结果写入结构体
+// dest 为结构体切片指针(*[]T 或 *[]*T)时写入全部行；为结构体指针时写入第一行，没有数据返回 sql.ErrNoRows
+func utilScanInto(List *sql.Rows, dest interface{}) error {
+	// 1、检查dest类型
+	rv := reflect.ValueOf(dest)
+	if dest == nil || rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("dest必须是非nil指针")
+	}
+	rv = rv.Elem()
+
+	var (
+		isSlice  = rv.Kind() == reflect.Slice
+		elemType = rv.Type()
+		elemPtr  = false
+	)
+	if isSlice {
+		elemType = elemType.Elem()
+		if elemType.Kind() == reflect.Ptr {
+			elemType = elemType.Elem()
+			elemPtr = true
+		}
+	}
+	if elemType.Kind() != reflect.Struct {
+		return errors.New("dest必须是结构体指针或结构体切片指针，实际为:" + reflect.TypeOf(dest).String())
+	}
+
+	// 2、列名对应字段
+	columns, err := List.Columns()
+	if err != nil {
+		return err
+	}
+	fields := utilStructFields(elemType)
+
+	// 3、遍历数据
+	if isSlice {
+		rv.Set(reflect.MakeSlice(rv.Type(), 0, 0))
+	}
+	for List.Next() {
+		elem := reflect.New(elemType).Elem()
+		if !isSlice {
+			elem = rv
+		}
+
+		// 3.1、写入字段
+		if err := utilScanStructRow(List, columns, fields, elem); err != nil {
+			return err
+		}
+
+		// 3.2、单条直接返回
+		if !isSlice {
+			return List.Err()
+		}
+		if elemPtr {
+			rv.Set(reflect.Append(rv, elem.Addr()))
+		} else {
+			rv.Set(reflect.Append(rv, elem))
+		}
+	}
+	if err := List.Err(); err != nil {
+		return err
+	}
+	if !isSlice {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// 辅助函数: 当前行数据写入结构体elem，有对应字段的列写入字段，否则丢弃
+func utilScanStructRow(List *sql.Rows, columns []string, fields map[string][]int, elem reflect.Value) error {
+	scans := make([]interface{}, len(columns))
+	for i, col := range columns {
+		if index, ok := fields[col]; ok {
+			scans[i] = elem.FieldByIndex(index).Addr().Interface()
+		} else {
+			scans[i] = new(interface{})
+		}
+	}
+	if err := List.Scan(scans...); err != nil {
+		return fmt.Errorf("写入%s失败: %w", elem.Type().String(), err)
+	}
+	return nil
+}
+
+// 辅助函数: 获取结构体的 列名=>字段索引路径，匿名嵌入的结构体字段展开，外层字段优先
+func utilStructFields(t reflect.Type) map[string][]int {
+	if v, ok := structFieldsCache.Load(t); ok {
+		return v.(map[string][]int)
+	}
+
+	fields := map[string][]int{}
+	var walk func(t reflect.Type, parent []int)
+	walk = func(t reflect.Type, parent []int) {
+		var embedded []reflect.StructField
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("db")
+			if tag == "-" {
+				continue
+			}
+			// 匿名嵌入的结构体，在外层字段之后展开
+			if f.Anonymous && tag == "" && f.Type.Kind() == reflect.Struct {
+				embedded = append(embedded, f)
+				continue
+			}
+			// 未导出字段忽略
+			if f.PkgPath != "" {
+				continue
+			}
+			name := tag
+			if name == "" {
+				name = strings.ToLower(f.Name)
+			}
+			if _, ok := fields[name]; !ok {
+				fields[name] = append(append([]int{}, parent...), i)
+			}
+		}
+		for _, f := range embedded {
+			walk(f.Type, append(append([]int{}, parent...), f.Index...))
+		}
+	}
+	walk(t, nil)
+
+	structFieldsCache.Store(t, fields)
+	return fields
+}
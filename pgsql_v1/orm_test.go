@@ -0,0 +1,46 @@
+package pgsql_v1
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestDbPoolPick(t *testing.T) {
+	primary, _ := sql.Open("postgres", "host=p")
+	r1, _ := sql.Open("postgres", "host=r1")
+	r2, _ := sql.Open("postgres", "host=r2")
+	defer (&poolDbs{primary: primary, replicas: []*sql.DB{r1, r2}}).close()
+
+	for _, balance := range []string{BalanceRoundRobin, BalanceLeastConn} {
+		p := newDbPool(&poolDbs{primary: primary, replicas: []*sql.DB{r1, r2}, balance: balance, downUntil: make([]int64, 2)})
+		seen := map[int]bool{}
+		for i := 0; i < 4; i++ {
+			seen[p.pick()] = true
+		}
+		if balance == BalanceRoundRobin && len(seen) != 2 {
+			t.Errorf("%s: pick() = %v, want both replicas", balance, seen)
+		}
+
+		// 连接失败的只读库不再选择，已固定该只读库的读取改用主库
+		p.markDown(0)
+		for i := 0; i < 4; i++ {
+			if got := p.pick(); got != 1 {
+				t.Errorf("%s: pick() = %d after replica 0 down, want 1", balance, got)
+			}
+		}
+		if p.replica(0) != primary || p.replica(1) != r2 {
+			t.Errorf("%s: replica(0) should fall back to primary", balance)
+		}
+
+		// 全部不可用时使用主库
+		p.markDown(1)
+		if got := p.pick(); got != -1 {
+			t.Errorf("%s: pick() = %d with all replicas down, want -1", balance, got)
+		}
+	}
+
+	p := newDbPool(&poolDbs{primary: primary})
+	if got := p.pick(); got != -1 {
+		t.Errorf("pick() = %d without replicas, want -1", got)
+	}
+}
@@ -0,0 +1,144 @@
+package pgsql_v1
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"strconv"
+)
+
+// sql执行接口：*sql.DB 和 *sql.Tx 都实现了该接口
+type sqlExecutor interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// 事务状态：同一事务内的嵌套调用共享
+type txState struct {
+	tx        *sql.Tx // 事务句柄
+	savepoint int     // 已创建的保存点数量，用于生成保存点名称
+	cursor    int     // 已创建的游标数量，用于生成游标名称
+}
+
+// Tx 事务句柄，拥有和ormPgsql相同的Insert/Update/Delete/Query/QueryTable等方法，所有操作都在同一个事务内执行
+type Tx struct {
+	ormPgsql
+}
+
+// 获取执行sql的句柄：事务中返回事务句柄，否则返回数据库句柄
+func (Me ormPgsql) db() sqlExecutor {
+	if Me.tx != nil {
+		return Me.tx.tx
+	}
+	if Me.readIdx > 0 {
+		return Me.pool.replica(Me.readIdx - 1)
+	}
+	return Me.pool.get()
+}
+
+// Transaction 事务1：在事务中执行fn，fn返回nil则提交，返回错误或panic则回滚
+// 在Tx上嵌套调用时，使用 SAVEPOINT / ROLLBACK TO SAVEPOINT 只回滚嵌套部分
+// 示例:
+//
+//	err := Handle().Transaction(func(tx *pgsql_v1.Tx) error {
+//		if _, err := tx.Insert("user", map[string]interface{}{"user_name": "张三"}); err != nil {
+//			return err
+//		}
+//		return tx.Update("user_stat", map[string]interface{}{"num": 1}, map[string]interface{}{"id": 1})
+//	})
+func (Me ormPgsql) Transaction(fn func(tx *Tx) error) error {
+	return Me.TransactionCtx(context.Background(), fn)
+}
+
+// TransactionCtx 事务1：在事务中执行fn，可通过ctx取消或设置超时
+func (Me ormPgsql) TransactionCtx(ctx context.Context, fn func(tx *Tx) error) error {
+	return Me.TransactionOptCtx(ctx, nil, fn)
+}
+
+// TransactionOptCtx 事务2：指定隔离级别、只读等选项，在事务中执行fn，可通过ctx取消或设置超时
+// 示例:
+//
+//	err := Handle().TransactionOptCtx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true}, fn)
+//
+// 说明：已在事务中时使用保存点，opts不生效
+func (Me ormPgsql) TransactionOptCtx(ctx context.Context, opts *sql.TxOptions, fn func(tx *Tx) error) error {
+	if Me.initErr {
+		log.Error("数据库未连接成功", Me.dbCfgName, Me.dbName)
+		return errors.New("数据库未连接成功:" + Me.dbCfgName + " . " + Me.dbName)
+	}
+
+	// 已在事务中，使用保存点
+	if Me.tx != nil {
+		return Me.savepointCtx(ctx, fn)
+	}
+
+	// 1、开启事务
+	sqlTx, err := Me.pool.get().BeginTx(ctx, opts)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	Me.tx = &txState{tx: sqlTx}
+
+	// 2、panic时回滚后继续抛出
+	defer func() {
+		if p := recover(); p != nil {
+			_ = sqlTx.Rollback()
+			panic(p)
+		}
+	}()
+
+	// 3、执行，出错回滚
+	if err := fn(&Tx{ormPgsql: Me}); err != nil {
+		_ = sqlTx.Rollback()
+		return err
+	}
+
+	// 4、提交
+	if err := sqlTx.Commit(); err != nil {
+		log.Error(err)
+		return err
+	}
+	return nil
+}
+
+// 嵌套事务：创建保存点执行fn，fn返回错误或panic则回滚到保存点
+func (Me ormPgsql) savepointCtx(ctx context.Context, fn func(tx *Tx) error) error {
+	// 1、创建保存点
+	Me.tx.savepoint++
+	name := "pgsql_v1_sp_" + strconv.Itoa(Me.tx.savepoint)
+	if _, err := Me.tx.tx.ExecContext(ctx, "savepoint "+name); err != nil {
+		log.Error(err)
+		return err
+	}
+
+	// 2、panic时回滚到保存点后继续抛出
+	defer func() {
+		if p := recover(); p != nil {
+			_, _ = Me.tx.tx.ExecContext(context.Background(), "rollback to savepoint "+name)
+			panic(p)
+		}
+	}()
+
+	// 3、执行，出错回滚到保存点
+	if err := fn(&Tx{ormPgsql: Me}); err != nil {
+		if _, rbErr := Me.tx.tx.ExecContext(context.Background(), "rollback to savepoint "+name); rbErr != nil {
+			log.Error(rbErr)
+		}
+		return err
+	}
+
+	// 4、释放保存点
+	if _, err := Me.tx.tx.ExecContext(ctx, "release savepoint "+name); err != nil {
+		log.Error(err)
+		return err
+	}
+	return nil
+}
+
+// GetTx 获取底层事务句柄，需要直接执行sql的场合用
+func (Me Tx) GetTx() *sql.Tx {
+	return Me.tx.tx
+}
@@ -0,0 +1,322 @@
+package pgsql_v1
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// UUpsert 结构体6：Upsert可选参数
+type UUpsert struct {
+	Where         string                 // DO UPDATE 的附加条件，支持:name命名参数和?参数，如 "demo.updated < excluded.updated"
+	WhereArgs     map[string]interface{} // Where中的命名参数
+	WhereValues   []interface{}          // Where中?对应的参数，和写入数据的参数一起编号
+	Returning     []string               // 返回的字段，"*"为全部字段
+	CheckConflict bool                   // 是否检查冲突字段为主键或唯一索引
+}
+
+// Upsert 数据操作6： 写入数据，冲突时更新或忽略(insert ... on conflict)
+// conflictColumns 为冲突判断的字段(主键或唯一索引)；updateColumns 为冲突时更新的字段，为空时忽略(do nothing)，
+// ["*"] 表示更新row中除冲突字段外的全部字段
+// 示例:
+//
+//	row, err := Upsert("user", map[string]interface{}{"user_id": 123, "user_name": "张三"},
+//	    []string{"user_id"}, []string{"user_name"},
+//	    pgsql_v1.UUpsert{Returning: []string{"*"}},
+//	)
+//
+// 说明：设置了Returning时返回写入或更新后的数据；do nothing 或 Where不满足时没有数据返回，返回nil
+func (Me ormPgsql) Upsert(table string, row map[string]interface{}, conflictColumns []string, updateColumns []string, opt ...UUpsert) (map[string]interface{}, error) {
+	return Me.UpsertCtx(context.Background(), table, row, conflictColumns, updateColumns, opt...)
+}
+
+// UpsertCtx 数据操作6： 写入数据，冲突时更新或忽略，可通过ctx取消或设置超时
+func (Me ormPgsql) UpsertCtx(ctx context.Context, table string, row map[string]interface{}, conflictColumns []string, updateColumns []string, opt ...UUpsert) (map[string]interface{}, error) {
+	rows, err := Me.UpsertManyCtx(ctx, table, []map[string]interface{}{row}, conflictColumns, updateColumns, opt...)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	return rows[0], nil
+}
+
+// UpsertMany 数据操作7： 批量写入数据，冲突时更新或忽略
+// 说明：参数同Upsert，每行的字段必须一致，分批写入规则同InsertMany；设置了Returning时返回写入或更新后的数据；
+// 冲突时更新(do update)的，冲突字段值相同的多行只写入最后一行(同一语句不能两次更新同一行)，位置为第一次出现的位置；
+// 冲突字段有nil的行不去重
+func (Me ormPgsql) UpsertMany(table string, rows []map[string]interface{}, conflictColumns []string, updateColumns []string, opt ...UUpsert) ([]map[string]interface{}, error) {
+	return Me.UpsertManyCtx(context.Background(), table, rows, conflictColumns, updateColumns, opt...)
+}
+
+// UpsertManyCtx 数据操作7： 批量写入数据，冲突时更新或忽略，可通过ctx取消或设置超时
+func (Me ormPgsql) UpsertManyCtx(ctx context.Context, table string, rows []map[string]interface{}, conflictColumns []string, updateColumns []string, opt ...UUpsert) ([]map[string]interface{}, error) {
+	if Me.initErr {
+		log.Error("数据库未连接成功", Me.dbCfgName, Me.dbName)
+		return nil, errors.New("数据库未连接成功:" + Me.dbCfgName + " . " + Me.dbName)
+	}
+
+	if len(rows) <= 0 {
+		return nil, nil
+	}
+
+	// 1、可选参数
+	KeyOpt := UUpsert{}
+	if len(opt) > 0 {
+		KeyOpt = opt[0]
+	}
+
+	// 2、检查冲突字段
+	if KeyOpt.CheckConflict {
+		if err := Me.checkConflictCtx(ctx, table, conflictColumns); err != nil {
+			log.Error(err)
+			return nil, err
+		}
+	}
+
+	// 3、on conflict 语句
+	KeySuffix, KeyArgs, err := utilUpsertSql(rows[0], conflictColumns, updateColumns, KeyOpt)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	// 4、冲突时更新的，冲突字段值相同的行只保留最后一行
+	if strings.Contains(KeySuffix, " do update set ") {
+		rows = utilUpsertDedupe(rows, conflictColumns)
+	}
+
+	// 5、分批写入，读取返回的数据
+	KeyRows := make([]map[string]interface{}, 0)
+	var scan func(List *sql.Rows) error
+	if len(KeyOpt.Returning) > 0 {
+		scan = func(List *sql.Rows) error {
+			data, err := utilScan(List, Me.scanMode)
+			if err != nil {
+				return err
+			}
+			KeyRows = append(KeyRows, data...)
+			return nil
+		}
+	}
+	if err := Me.insertBatchesCtx(ctx, table, rows, KeySuffix, KeyArgs, false, scan); err != nil {
+		return nil, err
+	}
+
+	return KeyRows, nil
+}
+
+// DescUniqueKeys 表结构信息5：获取数据表的主键和唯一索引，每项为一个索引的字段列表(按索引中的顺序)
+// 说明：不包含表达式索引和部分索引(带where的索引)，这两类索引不能直接作为 on conflict 的冲突字段
+func (Me ormPgsql) DescUniqueKeys(tbName string) ([][]string, error) {
+	return Me.DescUniqueKeysCtx(context.Background(), tbName)
+}
+
+// DescUniqueKeysCtx 表结构信息5：获取数据表的主键和唯一索引，可通过ctx取消或设置超时
+func (Me ormPgsql) DescUniqueKeysCtx(ctx context.Context, tbName string) ([][]string, error) {
+	if Me.initErr {
+		log.Error("数据库未连接成功", Me.dbCfgName, Me.dbName)
+		return nil, errors.New("数据库未连接成功:" + Me.dbCfgName + " . " + Me.dbName)
+	}
+
+	// 1、表名
+	KeyTable, err := UtilQuoteTable(tbName)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	// 2、读取数据，主键在前
+	sql := `SELECT array_to_string(array_agg(a.attname ORDER BY k.ord), ',') AS cols
+FROM pg_index AS i
+JOIN LATERAL unnest(i.indkey) WITH ORDINALITY AS k(attnum, ord) ON true
+JOIN pg_attribute AS a ON a.attrelid = i.indrelid AND a.attnum = k.attnum
+WHERE i.indrelid = :table::regclass AND i.indisunique AND i.indexprs IS NULL AND i.indpred IS NULL
+GROUP BY i.indexrelid, i.indisprimary
+ORDER BY i.indisprimary DESC, i.indexrelid`
+	res, err := Me.WithScanMode(ScanString).QueryCtx(ctx, sql, map[string]interface{}{"table": KeyTable})
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	// 3、构造返回数据
+	KeyRet := make([][]string, 0, len(res))
+	for _, v := range res {
+		KeyRet = append(KeyRet, strings.Split(v["cols"].(string), ","))
+	}
+	return KeyRet, nil
+}
+
+// 检查冲突字段是否和主键或某个唯一索引的字段完全一致
+func (Me ormPgsql) checkConflictCtx(ctx context.Context, table string, conflictColumns []string) error {
+	keys, err := Me.DescUniqueKeysCtx(ctx, table)
+	if err != nil {
+		return err
+	}
+	want := utilSortedStrings(conflictColumns)
+	for _, key := range keys {
+		if strings.Join(utilSortedStrings(key), ",") == strings.Join(want, ",") {
+			return nil
+		}
+	}
+	return errors.New(strings.Join(conflictColumns, ",") + " 不是 " + table + " 的主键或唯一索引")
+}
+
+// 辅助函数: 生成 on conflict ... 语句，返回语句和其中的参数
+func utilUpsertSql(row map[string]interface{}, conflictColumns []string, updateColumns []string, opt UUpsert) (string, []interface{}, error) {
+	// 1、冲突字段
+	if len(conflictColumns) == 0 {
+		return "", nil, errors.New("冲突字段不能为空")
+	}
+	conflicts := make([]string, len(conflictColumns))
+	isConflict := map[string]bool{}
+	for i, v := range conflictColumns {
+		field, err := UtilQuoteIdent(v)
+		if err != nil {
+			return "", nil, err
+		}
+		conflicts[i] = field
+		isConflict[utilUnquoteIdent(v)] = true
+	}
+	KeySql := " on conflict (" + strings.Join(conflicts, ",") + ")"
+	KeyArgs := make([]interface{}, 0)
+
+	// 2、更新的字段，"*"为row中除冲突字段外的全部字段
+	if len(updateColumns) == 1 && updateColumns[0] == "*" {
+		updateColumns = make([]string, 0, len(row))
+		for _, k := range utilSortedKeys(row) {
+			if !isConflict[k] {
+				updateColumns = append(updateColumns, k)
+			}
+		}
+	}
+	if len(updateColumns) == 0 {
+		KeySql += " do nothing"
+	} else {
+		sets := make([]string, len(updateColumns))
+		for i, v := range updateColumns {
+			field, err := UtilQuoteIdent(v)
+			if err != nil {
+				return "", nil, err
+			}
+			sets[i] = field + "=excluded." + field
+		}
+		KeySql += " do update set " + strings.Join(sets, ",")
+
+		// 更新的附加条件
+		if opt.Where != "" {
+			where, args, err := utilUpsertWhere(opt)
+			if err != nil {
+				return "", nil, err
+			}
+			KeySql += " where " + where
+			KeyArgs = append(KeyArgs, args...)
+		}
+	}
+
+	// 3、返回的字段
+	if len(opt.Returning) > 0 {
+		returning, err := utilReturningSql(opt.Returning)
+		if err != nil {
+			return "", nil, err
+		}
+		KeySql += returning
+	}
+
+	return KeySql, KeyArgs, nil
+}
+
+// 辅助函数: 转换 DO UPDATE 的附加条件，?按顺序使用WhereValues，:name使用WhereArgs，参数按出现的顺序返回；
+// 语句中保留?，和写入数据的?一起编号
+func utilUpsertWhere(opt UUpsert) (string, []interface{}, error) {
+	// ?转换成命名参数，和:name一起处理
+	KeySql := strings.Builder{}
+	KeyArgs := make(map[string]interface{}, len(opt.WhereArgs)+len(opt.WhereValues))
+	for k, v := range opt.WhereArgs {
+		KeyArgs[k] = v
+	}
+	n := 0
+	for _, t := range utilSqlTokens(opt.Where) {
+		if t.kind != sqlTokPlaceholder {
+			KeySql.WriteString(t.text)
+			continue
+		}
+		if n >= len(opt.WhereValues) {
+			return "", nil, errors.New("Where中?的个数多于WhereValues的个数")
+		}
+		name := "pgsql_v1_where_" + strconv.Itoa(n)
+		if _, ok := KeyArgs[name]; ok {
+			return "", nil, errors.New("WhereArgs不能使用保留的参数名: " + name)
+		}
+		KeyArgs[name] = opt.WhereValues[n]
+		KeySql.WriteString(":" + name)
+		n++
+	}
+	if n != len(opt.WhereValues) {
+		return "", nil, errors.New("Where中?的个数少于WhereValues的个数")
+	}
+	return utilMakeCondition(KeySql.String(), KeyArgs)
+}
+
+// 辅助函数: 冲突字段值相同的行只保留最后一行，位置为第一次出现的位置；冲突字段缺少或为nil的行不去重
+func utilUpsertDedupe(rows []map[string]interface{}, conflictColumns []string) []map[string]interface{} {
+	KeyRows := make([]map[string]interface{}, 0, len(rows))
+	index := map[string]int{}
+	for _, row := range rows {
+		key := make([]string, 0, len(conflictColumns))
+		for _, c := range conflictColumns {
+			v, ok := row[utilUnquoteIdent(c)]
+			if !ok || v == nil {
+				key = nil
+				break
+			}
+			key = append(key, fmt.Sprint(v))
+		}
+		if key == nil {
+			KeyRows = append(KeyRows, row)
+			continue
+		}
+		k := strings.Join(key, "\x00")
+		if i, ok := index[k]; ok {
+			KeyRows[i] = row
+			continue
+		}
+		index[k] = len(KeyRows)
+		KeyRows = append(KeyRows, row)
+	}
+	return KeyRows
+}
+
+// 辅助函数: 生成 returning 语句，"*"为全部字段，其他字段加上双引号
+func utilReturningSql(columns []string) (string, error) {
+	fields := make([]string, len(columns))
+	for i, v := range columns {
+		if v == "*" {
+			fields[i] = v
+			continue
+		}
+		field, err := UtilQuoteIdent(v)
+		if err != nil {
+			return "", err
+		}
+		fields[i] = field
+	}
+	return " returning " + strings.Join(fields, ","), nil
+}
+
+// 辅助函数: 字符串切片排序，不修改原切片
+func utilSortedStrings(list []string) []string {
+	ret := make([]string, len(list))
+	for i, v := range list {
+		ret[i] = utilUnquoteIdent(v)
+	}
+	sort.Strings(ret)
+	return ret
+}
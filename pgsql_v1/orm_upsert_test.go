@@ -0,0 +1,90 @@
+package pgsql_v1
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUtilUpsertSql(t *testing.T) {
+	row := map[string]interface{}{"id": 1, "status": 2, "debug": "x"}
+
+	t.Run("do update", func(t *testing.T) {
+		got, args, err := utilUpsertSql(row, []string{"id"}, []string{"*"}, UUpsert{Returning: []string{"*"}})
+		want := ` on conflict ("id") do update set "debug"=excluded."debug","status"=excluded."status" returning *`
+		if err != nil || got != want || len(args) != 0 {
+			t.Errorf("got %q %v %v, want %q", got, args, err, want)
+		}
+	})
+
+	t.Run("do nothing", func(t *testing.T) {
+		got, _, err := utilUpsertSql(row, []string{"id", `"status"`}, nil, UUpsert{})
+		if want := ` on conflict ("id","status") do nothing`; err != nil || got != want {
+			t.Errorf("got %q %v, want %q", got, err, want)
+		}
+	})
+
+	// Where中的?和:name按出现的顺序，和values的参数一起编号
+	t.Run("where args", func(t *testing.T) {
+		opt := UUpsert{
+			Where:       "demo.status < ? and demo.debug <> :debug and demo.id in (::ids) and demo.tags ? 'vip'",
+			WhereArgs:   map[string]interface{}{"debug": "locked", "ids": []int{7, 8}},
+			WhereValues: []interface{}{9},
+		}
+		suffix, args, err := utilUpsertSql(row, []string{"id"}, []string{"status"}, opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := UtilFormatExecE(utilInsertBatchSql(`"demo"`, []string{`"id"`, `"status"`}, nil, 2) + suffix)
+		want := `insert into "demo"("id","status") values ($1,$2),($3,$4) on conflict ("id") do update set "status"=excluded."status"` +
+			` where demo.status < $5 and demo.debug <> $6 and demo.id in ($7,$8) and demo.tags ? 'vip'`
+		if err != nil || got != want {
+			t.Errorf("got %q %v\nwant %q", got, err, want)
+		}
+		if !reflect.DeepEqual(args, []interface{}{9, "locked", 7, 8}) {
+			t.Errorf("args = %v", args)
+		}
+	})
+
+	for name, opt := range map[string]UUpsert{
+		"too few values":  {Where: "a = ? and b = ?", WhereValues: []interface{}{1}},
+		"too many values": {Where: "a = ?", WhereValues: []interface{}{1, 2}},
+		"missing arg":     {Where: "a = :a"},
+		"positional":      {Where: "a = $1 and b = ?", WhereValues: []interface{}{1}},
+	} {
+		if _, _, err := utilUpsertSql(row, []string{"id"}, []string{"status"}, opt); err == nil {
+			t.Errorf("%s: want error", name)
+		}
+	}
+	if _, _, err := utilUpsertSql(row, nil, nil, UUpsert{}); err == nil {
+		t.Error("empty conflict columns: want error")
+	}
+}
+
+func TestUtilReturningSql(t *testing.T) {
+	got, err := utilReturningSql([]string{"id", "*", `"User"`})
+	if want := ` returning "id",*,"User"`; err != nil || got != want {
+		t.Errorf("utilReturningSql = %q, %v, want %q", got, err, want)
+	}
+	if _, err := utilReturningSql([]string{""}); err == nil {
+		t.Error("utilReturningSql with empty field: want error")
+	}
+}
+
+func TestUtilUpsertDedupe(t *testing.T) {
+	rows := []map[string]interface{}{
+		{"a": 1, "b": "x", "v": 1},
+		{"a": 2, "b": "x", "v": 2},
+		{"a": 1, "b": "x", "v": 3},
+		{"a": nil, "b": "x", "v": 4},
+		{"a": nil, "b": "x", "v": 5},
+		{"a": 2, "b": "y", "v": 6},
+	}
+	got := utilUpsertDedupe(rows, []string{"a", `"b"`})
+	vs := make([]interface{}, len(got))
+	for i, row := range got {
+		vs[i] = row["v"]
+	}
+	if want := []interface{}{3, 2, 4, 5, 6}; !reflect.DeepEqual(vs, want) {
+		t.Errorf("utilUpsertDedupe kept v = %v, want %v", vs, want)
+	}
+}
@@ -0,0 +1,227 @@
+package pgsql_v1
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// UOr 结构体8：or条件组，组内每项为一个条件map(map内用and连接)，各项之间用or连接；作为条件map的值使用，键名只作标记
+// 示例: map[string]interface{}{ "status": 1, "or#1": pgsql_v1.UOr{ {"age >": 18}, {"vip": true} } }
+type UOr []map[string]interface{}
+
+// URaw 结构体9：原样使用的条件语句，支持?参数；作为条件map的值使用，键名只作标记
+// 示例: map[string]interface{}{ "raw#1": pgsql_v1.URaw{Sql: "created > now() - interval '1 day'"} }
+type URaw struct {
+	Sql  string        // 条件语句
+	Args []interface{} // 条件语句中?对应的参数
+}
+
+// 支持的条件运算符
+var whereOperators = map[string]bool{
+	"=": true, "!=": true, "<>": true, ">": true, ">=": true, "<": true, "<=": true,
+	"like": true, "ilike": true, "not like": true, "not ilike": true,
+	"in": true, "not in": true, "between": true, "not between": true,
+	"is": true, "is not": true,
+}
+
+// 辅助函数: 条件map转换成where语句(不含where关键字)和参数，各条件用and连接，没有条件时为 true
+// 键名格式为 "字段 运算符"，运算符省略时为 =，字段可以是 table.field 格式：
+//
+//	"id": 1                           => "id" = ?
+//	"id": nil                         => "id" is null
+//	"id !=": nil                      => "id" is not null
+//	"id": []int{1, 2}                 => "id" in (?,?)，空切片为 false
+//	"id not in": []int{1, 2}          => "id" not in (?,?)，空切片为 true
+//	"tags": pq.StringArray{"a", "b"}  => "tags" = ?，[]byte 和实现了 driver.Valuer 的切片不展开
+//	"age >": 18                       => "age" > ?，支持 = != <> > >= < <=
+//	"name ilike": "%张%"               => "name" ilike ?，支持 like ilike not like / not ilike
+//	"created between": []interface{}{a, b} => "created" between ? and ?，支持 not between
+//	"vip is": true                    => "vip" is true，支持 is not 和 nil/true/false
+//	"任意键名": pgsql_v1.UOr{...}       => (条件1 or 条件2)
+//	"任意键名": pgsql_v1.URaw{...}      => (原样条件)
+//	"任意键名": map[string]interface{}{...} => (条件1 and 条件2)
+//	"id": Handle().Select("id").From("t") => "id" in (子查询)，支持 not in 和比较运算符
+func utilBuildWhere(conditions map[string]interface{}) (string, []interface{}, error) {
+	KeyArgs := make([]interface{}, 0)
+	KeyWheres := make([]string, 0, len(conditions))
+	for _, k := range utilSortedKeys(conditions) {
+		where, args, err := utilBuildCondition(k, conditions[k])
+		if err != nil {
+			return "", nil, err
+		}
+		KeyWheres = append(KeyWheres, where)
+		KeyArgs = append(KeyArgs, args...)
+	}
+	if len(KeyWheres) == 0 {
+		return "true", KeyArgs, nil
+	}
+	return strings.Join(KeyWheres, " and "), KeyArgs, nil
+}
+
+// 辅助函数: 单个条件转换成sql语句和参数
+func utilBuildCondition(key string, value interface{}) (string, []interface{}, error) {
+	// 1、条件组和原样条件，键名只作标记
+	switch inst := value.(type) {
+	case UOr:
+		if len(inst) == 0 {
+			return "false", nil, nil
+		}
+		KeyArgs := make([]interface{}, 0)
+		KeyWheres := make([]string, 0, len(inst))
+		for _, m := range inst {
+			where, args, err := utilBuildWhere(m)
+			if err != nil {
+				return "", nil, err
+			}
+			KeyWheres = append(KeyWheres, "("+where+")")
+			KeyArgs = append(KeyArgs, args...)
+		}
+		return "(" + strings.Join(KeyWheres, " or ") + ")", KeyArgs, nil
+	case URaw:
+		return "(" + inst.Sql + ")", inst.Args, nil
+	case map[string]interface{}:
+		where, args, err := utilBuildWhere(inst)
+		if err != nil {
+			return "", nil, err
+		}
+		return "(" + where + ")", args, nil
+	}
+
+	// 2、拆分字段和运算符
+	field, op, err := utilSplitConditionKey(key)
+	if err != nil {
+		return "", nil, err
+	}
+	if op != "" && !whereOperators[op] {
+		return "", nil, errors.New("不支持的条件运算符: " + key)
+	}
+
+	// 3、nil
+	if value == nil {
+		switch op {
+		case "", "=", "is":
+			return field + " is null", nil, nil
+		case "!=", "<>", "is not":
+			return field + " is not null", nil, nil
+		default:
+			return "", nil, errors.New("条件 " + key + " 的值不能为nil")
+		}
+	}
+
+	// 4、子查询，运算符省略时为 in
+	if sub, ok := value.(*USelect); ok {
+		if op == "" {
+			op = "in"
+		}
+		if op != "in" && op != "not in" && op != "=" && op != "!=" && op != "<>" && op != ">" && op != ">=" && op != "<" && op != "<=" {
+			return "", nil, errors.New("条件 " + key + " 不支持子查询")
+		}
+		subSql, subArgs, err := sub.build()
+		if err != nil {
+			return "", nil, err
+		}
+		return field + " " + op + " (" + subSql + ")", subArgs, nil
+	}
+
+	// 5、is / is not 只支持布尔值
+	if op == "is" || op == "is not" {
+		b, ok := value.(bool)
+		if !ok {
+			return "", nil, fmt.Errorf("条件 %s 的值必须是nil或布尔值，实际为: %T", key, value)
+		}
+		if b {
+			return field + " " + op + " true", nil, nil
+		}
+		return field + " " + op + " false", nil, nil
+	}
+
+	// 6、切片：in、not in、between；[]byte 和实现了 driver.Valuer 的切片(如 pq.StringArray)作为一个值
+	rv := reflect.ValueOf(value)
+	_, isValuer := value.(driver.Valuer)
+	isList := (rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array) && rv.Type().Elem().Kind() != reflect.Uint8 && !isValuer
+	switch op {
+	case "between", "not between":
+		if !isList || rv.Len() != 2 {
+			return "", nil, errors.New("条件 " + key + " 的值必须是2个元素的切片")
+		}
+		return field + " " + op + " ? and ?", []interface{}{rv.Index(0).Interface(), rv.Index(1).Interface()}, nil
+	case "", "=", "in", "!=", "<>", "not in":
+		if !isList {
+			if op == "in" || op == "not in" {
+				return "", nil, fmt.Errorf("条件 %s 的值必须是切片，实际为: %T", key, value)
+			}
+			break
+		}
+		not := op == "!=" || op == "<>" || op == "not in"
+		if rv.Len() == 0 {
+			if not {
+				return "true", nil, nil
+			}
+			return "false", nil, nil
+		}
+		KeyArgs := make([]interface{}, rv.Len())
+		for i := range KeyArgs {
+			KeyArgs[i] = rv.Index(i).Interface()
+		}
+		flags := strings.TrimSuffix(strings.Repeat("?,", rv.Len()), ",")
+		if not {
+			return field + " not in (" + flags + ")", KeyArgs, nil
+		}
+		return field + " in (" + flags + ")", KeyArgs, nil
+	}
+	if isList {
+		return "", nil, fmt.Errorf("条件 %s 的值不能是切片", key)
+	}
+
+	// 7、比较运算
+	if op == "" {
+		op = "="
+	}
+	return field + " " + op + " ?", []interface{}{value}, nil
+}
+
+// 辅助函数: 条件键名拆分成加上双引号的字段和小写的运算符
+func utilSplitConditionKey(key string) (string, string, error) {
+	key = strings.TrimSpace(key)
+
+	// 1、字段：第一个不在双引号里的空白或运算符之前
+	i := 0
+	for i < len(key) {
+		c := key[i]
+		if c == '"' {
+			i = utilSqlSkipQuoted(key, i, '"', false)
+			continue
+		}
+		if c == ' ' || c == '\t' || c == '=' || c == '!' || c == '<' || c == '>' {
+			break
+		}
+		i++
+	}
+	field, err := utilQuoteColumn(key[:i])
+	if err != nil {
+		return "", "", err
+	}
+
+	// 2、运算符：多个空白合并成一个
+	op := strings.ToLower(strings.Join(strings.Fields(key[i:]), " "))
+	return field, op, nil
+}
+
+// 辅助函数: 字段名加上双引号，支持 table.field、schema.table.field 格式
+func utilQuoteColumn(name string) (string, error) {
+	parts := utilSplitDotted(name)
+	if len(parts) > 3 {
+		return "", errors.New("非法的字段名: " + name)
+	}
+	for i, v := range parts {
+		field, err := UtilQuoteIdent(v)
+		if err != nil {
+			return "", err
+		}
+		parts[i] = field
+	}
+	return strings.Join(parts, "."), nil
+}
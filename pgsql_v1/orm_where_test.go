@@ -0,0 +1,56 @@
+package pgsql_v1
+
+import (
+	"github.com/lib/pq"
+	"reflect"
+	"testing"
+)
+
+func TestUtilBuildWhere(t *testing.T) {
+	cases := []struct {
+		cond    map[string]interface{}
+		want    string
+		args    []interface{}
+		wantErr bool
+	}{
+		{map[string]interface{}{}, "true", []interface{}{}, false},
+		{map[string]interface{}{"id": 1}, `"id" = ?`, []interface{}{1}, false},
+		{map[string]interface{}{"id": nil}, `"id" is null`, []interface{}{}, false},
+		{map[string]interface{}{"id !=": nil}, `"id" is not null`, []interface{}{}, false},
+		{map[string]interface{}{"id": []int{1, 2}}, `"id" in (?,?)`, []interface{}{1, 2}, false},
+		{map[string]interface{}{"id": []int{}}, "false", []interface{}{}, false},
+		{map[string]interface{}{"id not in": []int{}}, "true", []interface{}{}, false},
+		{map[string]interface{}{"id !=": []int{3}}, `"id" not in (?)`, []interface{}{3}, false},
+		{map[string]interface{}{"age>=": 18, "name  NOT ILIKE": "%a%"}, `"age" >= ? and "name" not ilike ?`, []interface{}{18, "%a%"}, false},
+		{map[string]interface{}{"t.created between": []interface{}{1, 2}}, `"t"."created" between ? and ?`, []interface{}{1, 2}, false},
+		{map[string]interface{}{"vip is not": true}, `"vip" is not true`, []interface{}{}, false},
+		{map[string]interface{}{"data": []byte("x")}, `"data" = ?`, []interface{}{[]byte("x")}, false},
+		{map[string]interface{}{"tags": pq.StringArray{"a", "b"}}, `"tags" = ?`, []interface{}{pq.StringArray{"a", "b"}}, false},
+		{map[string]interface{}{"ids <>": pq.Int64Array{1}}, `"ids" <> ?`, []interface{}{pq.Int64Array{1}}, false},
+		{map[string]interface{}{"tags in": pq.StringArray{"a"}}, "", nil, true},
+		{map[string]interface{}{"status": 1, "or#1": UOr{{"age >": 18}, {"vip": true}}},
+			`(("age" > ?) or ("vip" = ?)) and "status" = ?`, []interface{}{18, true, 1}, false},
+		{map[string]interface{}{"or#1": UOr{}}, "false", []interface{}{}, false},
+		{map[string]interface{}{"raw#1": URaw{Sql: "a > ?", Args: []interface{}{1}}}, "(a > ?)", []interface{}{1}, false},
+		{map[string]interface{}{"and#1": map[string]interface{}{"a": 1, "b": 2}}, `("a" = ? and "b" = ?)`, []interface{}{1, 2}, false},
+		{map[string]interface{}{"id ~": 1}, "", nil, true},
+		{map[string]interface{}{"id >": nil}, "", nil, true},
+		{map[string]interface{}{"id in": 1}, "", nil, true},
+		{map[string]interface{}{"id >": []int{1}}, "", nil, true},
+		{map[string]interface{}{"id between": []int{1}}, "", nil, true},
+		{map[string]interface{}{"vip is": 1}, "", nil, true},
+		{map[string]interface{}{`"a""b"`: 1}, `"a""b" = ?`, []interface{}{1}, false},
+		{map[string]interface{}{"a.b.c.d": 1}, "", nil, true},
+		{map[string]interface{}{">": 1}, "", nil, true},
+	}
+	for _, c := range cases {
+		got, args, err := utilBuildWhere(c.cond)
+		if (err != nil) != c.wantErr {
+			t.Errorf("utilBuildWhere(%v) err = %v, wantErr %v", c.cond, err, c.wantErr)
+			continue
+		}
+		if err == nil && (got != c.want || !reflect.DeepEqual(args, c.args)) {
+			t.Errorf("utilBuildWhere(%v) = %q %v, want %q %v", c.cond, got, args, c.want, c.args)
+		}
+	}
+}